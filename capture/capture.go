@@ -0,0 +1,170 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/mux"
+	"github.com/oclaw/shnotify/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Collect describes the environment of the shell with the parent pid.
+// It is executed by the shell hooks so only cheap lookups are done here, no external commands are run.
+func Collect(cfg *config.CaptureConfig, parentPID int) *types.InvocationContext {
+	ic := &types.InvocationContext{
+		TTY:   tty(parentPID),
+		Shell: shell(parentPID),
+	}
+
+	ic.Cwd, _ = os.Getwd()
+	if u, err := user.Current(); err == nil {
+		ic.User = u.Username
+	} else {
+		ic.User = os.Getenv("USER")
+	}
+	ic.ShellLevel, _ = strconv.Atoi(os.Getenv("SHLVL"))
+	if pane := os.Getenv("TMUX_PANE"); len(pane) != 0 {
+		ic.TmuxSocket = mux.TmuxSocket(os.Getenv("TMUX"))
+		ic.TmuxPane = pane
+	}
+	if sty := os.Getenv("STY"); len(sty) != 0 {
+		ic.Screen = sty
+		ic.ScreenWindow = os.Getenv("WINDOW")
+	}
+
+	if cfg.Git && len(ic.Cwd) != 0 {
+		ic.GitRoot, ic.GitBranch = gitRepo(ic.Cwd)
+	}
+	if cfg.VirtualEnv {
+		ic.VirtualEnv = virtualEnv()
+	}
+	if cfg.KubeContext {
+		ic.KubeContext = kubeContext()
+	}
+	if len(cfg.Env) != 0 {
+		ic.Env = env(cfg.Env)
+	}
+
+	return ic
+}
+
+// tty returns the terminal attached to stdin, the one of the shell is used if the stdin is redirected
+func tty(parentPID int) string {
+	for _, fd := range []string{"/proc/self/fd/0", fmt.Sprintf("/proc/%d/fd/0", parentPID)} {
+		name, err := os.Readlink(fd)
+		if err == nil && (strings.HasPrefix(name, "/dev/pts/") || strings.HasPrefix(name, "/dev/tty")) {
+			return name
+		}
+	}
+	return ""
+}
+
+// shell returns the name of the parent process, login shell is used if it is not available
+func shell(parentPID int) string {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", parentPID))
+	if err == nil {
+		return strings.TrimSpace(string(comm))
+	}
+	if loginShell := os.Getenv("SHELL"); len(loginShell) != 0 {
+		return path.Base(loginShell)
+	}
+	return ""
+}
+
+// gitRepo finds the repository containing the directory and its current branch (short commit hash if detached)
+func gitRepo(dir string) (root, branch string) {
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			gitDir := dotGit
+			if !info.IsDir() {
+				// worktrees and submodules reference the git dir with 'gitdir: <path>'
+				raw, err := os.ReadFile(dotGit)
+				if err != nil {
+					return dir, ""
+				}
+				gitDir = strings.TrimSpace(strings.TrimPrefix(string(raw), "gitdir:"))
+				if !filepath.IsAbs(gitDir) {
+					gitDir = filepath.Join(dir, gitDir)
+				}
+			}
+			return dir, gitHead(gitDir)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+func gitHead(gitDir string) string {
+	raw, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(raw))
+	if ref, ok := strings.CutPrefix(head, "ref: "); ok {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	if len(head) > 7 {
+		head = head[:7]
+	}
+	return head
+}
+
+func virtualEnv() string {
+	if venv := os.Getenv("VIRTUAL_ENV"); len(venv) != 0 {
+		return venv
+	}
+	return os.Getenv("CONDA_DEFAULT_ENV")
+}
+
+// kubeContext reads current-context of the first kubeconfig file
+func kubeContext() string {
+	kubeConfig := os.Getenv("KUBECONFIG")
+	if len(kubeConfig) != 0 {
+		kubeConfig = filepath.SplitList(kubeConfig)[0]
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		kubeConfig = filepath.Join(home, ".kube", "config")
+	}
+
+	raw, err := os.ReadFile(kubeConfig)
+	if err != nil {
+		return ""
+	}
+	var parsed struct {
+		CurrentContext string `yaml:"current-context"`
+	}
+	if err := yaml.NewDecoder(bytes.NewReader(raw)).Decode(&parsed); err != nil {
+		return ""
+	}
+	return parsed.CurrentContext
+}
+
+func env(allowlist []string) map[string]string {
+	ret := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, val, _ := strings.Cut(kv, "=")
+		for _, pattern := range allowlist {
+			if ok, _ := path.Match(pattern, name); ok {
+				ret[name] = val
+				break
+			}
+		}
+	}
+	return ret
+}
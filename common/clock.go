@@ -1,9 +1,15 @@
 package common
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Clock interface {
 	NowUnix() int64
+	NowUnixMilli() int64
 }
 
 type DefaultClock struct{}
@@ -12,3 +18,40 @@ func (*DefaultClock) NowUnix() int64 {
 	now := time.Now()
 	return now.Unix()
 }
+
+func (*DefaultClock) NowUnixMilli() int64 {
+	now := time.Now()
+	return now.UnixMilli()
+}
+
+// Stamp completes the time given in seconds or milliseconds (the precise one wins), the clock is used if none is given
+func Stamp(clock Clock, sec, ms int64) (int64, int64) {
+	switch {
+	case ms != 0:
+		return ms / 1000, ms
+	case sec != 0:
+		return sec, sec * 1000
+	default:
+		ms = clock.NowUnixMilli()
+		return ms / 1000, ms
+	}
+}
+
+// ParseEpochMilli parses the unix time in seconds with the optional fraction (e.g. $EPOCHREALTIME) into milliseconds.
+// The fraction may be separated by comma as the shells use the decimal point of the locale.
+func ParseEpochMilli(value string) (int64, error) {
+	sec, frac, _ := strings.Cut(strings.Replace(value, ",", ".", 1), ".")
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("'%s' is not a unix time", value)
+	}
+	if strings.Trim(frac, "0123456789") != "" {
+		return 0, fmt.Errorf("'%s' is not a unix time", value)
+	}
+	frac = (frac + "000")[:3]
+	ms, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a unix time", value)
+	}
+	return secs*1000 + ms, nil
+}
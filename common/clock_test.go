@@ -0,0 +1,64 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/oclaw/shnotify/common/clocktest"
+)
+
+func TestStamp(t *testing.T) {
+	clock := clocktest.NewMilli(1700000000999)
+
+	tests := []struct {
+		name            string
+		sec, ms         int64
+		wantSec, wantMs int64
+	}{
+		{"clock if nothing given", 0, 0, 1700000000, 1700000000999},
+		{"seconds only", 1600000000, 0, 1600000000, 1600000000000},
+		{"milliseconds only", 0, 1600000000123, 1600000000, 1600000000123},
+		{"milliseconds win", 1500000000, 1600000000123, 1600000000, 1600000000123},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sec, ms := Stamp(clock, tt.sec, tt.ms)
+			if sec != tt.wantSec || ms != tt.wantMs {
+				t.Errorf("Stamp(%d, %d) = %d, %d, expected %d, %d", tt.sec, tt.ms, sec, ms, tt.wantSec, tt.wantMs)
+			}
+		})
+	}
+}
+
+func TestParseEpochMilli(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"1700000000", 1700000000000, false},
+		{"1700000000.5", 1700000000500, false},
+		{"1700000000.05", 1700000000050, false},
+		{"1700000000.123456", 1700000000123, false}, // $EPOCHREALTIME has microseconds
+		{"1700000000.999999", 1700000000999, false}, // truncated, not rounded
+		{"1700000000,123456", 1700000000123, false}, // decimal comma of the locale
+		{"1700000000.", 1700000000000, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"now", 0, true},
+		{"-1700000000", 0, true},
+		{"1700000000.1e3", 0, true},
+		{"1700000000.-12", 0, true},
+		{".5", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseEpochMilli(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEpochMilli(%q) error %v, expected error: %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseEpochMilli(%q) = %d, expected %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
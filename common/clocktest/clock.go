@@ -0,0 +1,47 @@
+// Package clocktest provides the clock of the tests, it moves only when the test sets it
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock implements common.Clock, it is safe to use from the timers of the code under test
+type Clock struct {
+	mu sync.Mutex
+	ms int64
+}
+
+// New returns the clock stopped at the unix time
+func New(unix int64) *Clock {
+	return &Clock{ms: unix * 1000}
+}
+
+// NewMilli returns the clock stopped at the unix time in milliseconds
+func NewMilli(ms int64) *Clock {
+	return &Clock{ms: ms}
+}
+
+func (c *Clock) NowUnix() int64 {
+	return c.NowUnixMilli() / 1000
+}
+
+func (c *Clock) NowUnixMilli() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ms
+}
+
+// Set moves the clock to the unix time
+func (c *Clock) Set(unix int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ms = unix * 1000
+}
+
+// Advance moves the clock forward
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ms += d.Milliseconds()
+}
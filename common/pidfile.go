@@ -0,0 +1,95 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+var ErrPIDFileLocked = errors.New("pid file is locked")
+
+// LockPIDFile takes the exclusive lock of the pid file and writes the pid of the process into it.
+// The lock is held until release is called or the process exits, so only one process owns the file.
+// It is the fcntl lock, the process must not open the file elsewhere since any close of it releases the lock.
+func LockPIDFile(filePath string) (release func(), err error) {
+	file, err := lockFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return func() {
+		// removed under the lock for the next owner not to take the stale file
+		_ = os.Remove(filePath)
+		_ = file.Close()
+	}, nil
+}
+
+func lockFile(filePath string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, writeLock()); err != nil {
+			_ = file.Close()
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+				pid, _ := PIDFileOwner(filePath)
+				return nil, fmt.Errorf("%w by process %d", ErrPIDFileLocked, pid)
+			}
+			return nil, err
+		}
+
+		// the previous owner may have removed the file between the open and the lock
+		locked, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(filePath); err == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+		_ = file.Close()
+	}
+}
+
+// PIDFileOwner returns the pid of the process holding the lock of the pid file, 0 if there is no such process.
+// The lock is only probed, the daemon taking it concurrently is not disturbed.
+func PIDFileOwner(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	lock := writeLock()
+	if err := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, lock); err != nil {
+		return 0, err
+	}
+	if lock.Type == syscall.F_UNLCK {
+		return 0, nil // stale file
+	}
+	return int(lock.Pid), nil
+}
+
+// writeLock describes the exclusive lock of the whole file
+func writeLock() *syscall.Flock_t {
+	return &syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: io.SeekStart,
+	}
+}
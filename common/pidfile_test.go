@@ -0,0 +1,74 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPIDFileHolder is the process holding the lock for TestPIDFileOwner, it is not run directly
+func TestPIDFileHolder(t *testing.T) {
+	filePath := os.Getenv("SHNOTIFY_TEST_PID_FILE")
+	if len(filePath) == 0 {
+		t.Skip("run by TestPIDFileOwner")
+	}
+	release, err := LockPIDFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	fmt.Println("locked")
+	_, _ = io.Copy(io.Discard, os.Stdin) // until the parent closes the pipe
+}
+
+func TestPIDFileOwner(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "shnotifyd.pid")
+	if pid, err := PIDFileOwner(filePath); pid != 0 || err != nil {
+		t.Errorf("PIDFileOwner() = %d, %v for the absent file", pid, err)
+	}
+
+	if err := os.WriteFile(filePath, []byte("12345\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := PIDFileOwner(filePath); pid != 0 || err != nil {
+		t.Errorf("PIDFileOwner() = %d, %v for the stale file", pid, err)
+	}
+
+	holder := exec.Command(os.Args[0], "-test.run=^TestPIDFileHolder$")
+	holder.Env = append(os.Environ(), "SHNOTIFY_TEST_PID_FILE="+filePath)
+	stdin, err := holder.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := holder.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = stdin.Close()
+		_ = holder.Wait()
+	}()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || strings.TrimSpace(line) != "locked" {
+		t.Fatalf("holder has not locked the file: %q, %v", line, err)
+	}
+
+	// probed repeatedly as 'daemon stop' does, the lock stays with the holder
+	for range 3 {
+		if pid, err := PIDFileOwner(filePath); pid != holder.Process.Pid || err != nil {
+			t.Fatalf("PIDFileOwner() = %d, %v, expected %d", pid, err, holder.Process.Pid)
+		}
+	}
+	_, err = LockPIDFile(filePath)
+	if !errors.Is(err, ErrPIDFileLocked) || !strings.Contains(err.Error(), fmt.Sprint(holder.Process.Pid)) {
+		t.Errorf("LockPIDFile() of the locked file returned %v", err)
+	}
+}
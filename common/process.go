@@ -0,0 +1,15 @@
+package common
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ProcessAlive reports whether the process with the pid exists on the local machine
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
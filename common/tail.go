@@ -0,0 +1,115 @@
+package common
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var ansiSequence = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+func StripANSI(text string) string {
+	return ansiSequence.ReplaceAllString(text, "")
+}
+
+// TailBuffer is the writer keeping the last lines of the output (bounded by count and size)
+// with terminal escape sequences stripped
+type TailBuffer struct {
+	maxLines int
+	maxBytes int
+
+	mu       sync.Mutex
+	lines    []string
+	size     int
+	current  []byte // unterminated line as written, compacted to its suffix if it grows too long
+	carriage bool   // '\r' is written, the line is redrawn unless it is the line break
+}
+
+// maxEscapeLen is the length of the escape sequence kept as is while the line is compacted, it may be incomplete
+const maxEscapeLen = 256
+
+func NewTailBuffer(maxLines, maxBytes int) *TailBuffer {
+	return &TailBuffer{
+		maxLines: maxLines,
+		maxBytes: maxBytes,
+	}
+}
+
+func (tb *TailBuffer) Write(p []byte) (int, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	for _, b := range p {
+		switch b {
+		case '\n':
+			tb.pushLine(string(tb.current))
+			tb.current = tb.current[:0]
+			tb.carriage = false
+		case '\r':
+			tb.carriage = true
+		default:
+			if tb.carriage {
+				// progress bars redraw the line, only the last state is interesting
+				tb.current = tb.current[:0]
+				tb.carriage = false
+			}
+			tb.current = append(tb.current, b)
+			if len(tb.current) > 2*tb.maxBytes+maxEscapeLen {
+				tb.compact()
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// compact keeps the end of the long unterminated line, the escape sequence at its end is kept as is
+// since the rest of it may be not written yet
+func (tb *TailBuffer) compact() {
+	text, pending := tb.current, []byte(nil)
+	if i := bytes.LastIndexByte(text, 0x1b); i >= 0 && len(text)-i <= maxEscapeLen {
+		text, pending = text[:i], text[i:]
+	}
+	compacted := truncateStart(StripANSI(string(text)), tb.maxBytes)
+	tb.current = append(append(make([]byte, 0, len(compacted)+len(pending)), compacted...), pending...)
+}
+
+func (tb *TailBuffer) pushLine(line string) {
+	line = truncateStart(StripANSI(line), tb.maxBytes)
+
+	tb.lines = append(tb.lines, line)
+	tb.size += len(line) + 1
+
+	for len(tb.lines) > tb.maxLines || (tb.size > tb.maxBytes && len(tb.lines) > 1) {
+		tb.size -= len(tb.lines[0]) + 1
+		tb.lines = tb.lines[1:]
+	}
+}
+
+// String returns the tail including the last unterminated line
+func (tb *TailBuffer) String() string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	lines := tb.lines
+	if last := truncateStart(StripANSI(string(tb.current)), tb.maxBytes); len(last) != 0 {
+		lines = append(lines[:len(lines):len(lines)], last)
+		if len(lines) > tb.maxLines {
+			lines = lines[1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncateStart returns at most maxBytes of the end of the text without splitting the runes
+func truncateStart(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	start := len(text) - maxBytes
+	for start < len(text) && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	return text[start:]
+}
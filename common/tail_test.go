@@ -0,0 +1,48 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxLines int
+		maxBytes int
+		writes   []string
+		want     string
+	}{
+		{"last lines", 2, 100, []string{"a\nb\nc\nd\n"}, "c\nd"},
+		{"unterminated line", 2, 100, []string{"a\nb\nc"}, "b\nc"},
+		{"lines split across writes", 3, 100, []string{"fir", "st\nsec", "ond\n"}, "first\nsecond"},
+		{"empty lines", 3, 100, []string{"a\n\nb\n"}, "a\n\nb"},
+		{"progress redraw", 2, 100, []string{"10%\r50%\r100%\ndone\n"}, "100%\ndone"},
+		{"progress redraw across writes", 2, 100, []string{"50%\r", "100%"}, "100%"},
+		{"carriage return at the end", 2, 100, []string{"50%\r"}, "50%"},
+		{"crlf", 3, 100, []string{"one\r\ntwo\r\n"}, "one\ntwo"},
+		{"crlf split across writes", 3, 100, []string{"one\r", "\ntwo\r", "\n"}, "one\ntwo"},
+		{"redraw before crlf", 3, 100, []string{"50%\r100%\r\n"}, "100%"},
+		{"escape sequences", 2, 100, []string{"\x1b[1;31mred\x1b[0m\n\x1b]0;title\x07plain\n"}, "red\nplain"},
+		{"size limit drops first lines", 10, 8, []string{"aaaa\nbbbb\ncccc\n"}, "cccc"},
+		{"long line keeps its end", 10, 4, []string{"abcdefgh\n"}, "efgh"},
+		{"long unterminated line keeps its end", 10, 4, []string{strings.Repeat("a", 1000) + "bcde"}, "bcde"},
+		{"long line written byte by byte", 10, 4, strings.Split(strings.Repeat("a", 1000)+"bcde\n", ""), "bcde"},
+		{"runes are not split", 10, 4, []string{"aпривет\n"}, "ет"},
+		{"escape sequences do not count", 10, 4, []string{"ab\x1b[1;31mcd\x1b[0m\n"}, "abcd"},
+		{"escape sequence across compaction", 10, 4, []string{strings.Repeat("a", 1000) + "\x1b[1;3", "1mbcde\x1b[0m"}, "bcde"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTailBuffer(tt.maxLines, tt.maxBytes)
+			for _, w := range tt.writes {
+				if n, err := tb.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := tb.String(); got != tt.want {
+				t.Errorf("tail %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
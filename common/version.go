@@ -0,0 +1,36 @@
+package common
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// Version returns the module version and the vcs revision the binary is built from
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	// pseudo-versions contain the revision already
+	if len(revision) != 0 && !strings.Contains(version, revision) {
+		version += " " + revision
+		if modified == "true" {
+			version += "-dirty"
+		}
+	}
+	return version
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"path"
 	"time"
@@ -28,7 +30,10 @@ func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
 	}
 	dd, err := time.ParseDuration(raw)
 	if err != nil {
-		return err
+		// type errors are collected by the decoder together with the position in the file
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: invalid duration '%s', expected value like 30s, 5m or 1h", value.Line, raw),
+		}}
 	}
 	*d = Duration(dd)
 	return nil
@@ -43,12 +48,101 @@ func (d *Duration) LessThan(sec int64) bool {
 }
 
 type NotificationConditions struct {
-	RunLongerThan *Duration `yaml:"run_longer_than"` // 30s, 1m, 1h
+	RunLongerThan     *Duration `yaml:"run_longer_than,omitempty"`     // 30s, 1m, 1h
+	StillRunningAfter *Duration `yaml:"still_running_after,omitempty"` // send in-progress notification if the command is still running after the period
+	Every             *Duration `yaml:"every,omitempty"`               // repeat in-progress notification with the period
+	OnShellDied       bool      `yaml:"on_shell_died,omitempty"`       // notify if the shell was closed while the command was running (respects run_longer_than)
+	OnlyWhenUnfocused bool      `yaml:"only_when_unfocused,omitempty"` // skip the commands whose tmux pane is in front of the user
+
+	// glob patterns of the invocation context fields (cwd, git_branch, env.NAME, ...), all of them must match.
+	// '/**' suffix matches the directory and everything below it
+	Context map[string]string `yaml:"context,omitempty"`
 }
 
 type Notification struct {
-	Type       types.NotificationType `yaml:"type"`
-	Conditions NotificationConditions `yaml:"conditions"`
+	Name         string                 `yaml:"name,omitempty"` // unique name of the notifier instance, type is used if empty
+	Type         types.NotificationType `yaml:"type"`
+	Conditions   NotificationConditions `yaml:"conditions"`
+	Format       string                 `yaml:"format,omitempty"`        // plain, markdown, markdownv2 or html. Default depends on the type
+	Template     string                 `yaml:"template,omitempty"`      // text/template of the message, default one is used if empty
+	TemplateFile string                 `yaml:"template_file,omitempty"` // path to the template, used if template is empty
+	Policy       NotificationPolicy     `yaml:"policy,omitempty"`
+	Schedule     *Schedule              `yaml:"schedule,omitempty"` // when the notifier is active, always if not set
+	Telegram     *TelegramOptions       `yaml:"telegram,omitempty"` // options of the telegram notifier
+	Tmux         *TmuxOptions           `yaml:"tmux,omitempty"`
+	OSC          *OSCOptions            `yaml:"osc,omitempty"` // options of the osc notifier
+}
+
+type TmuxOptions struct {
+	SelectPane bool `yaml:"select_pane,omitempty"` // make the pane of the command active once the notification is sent
+}
+
+type TelegramOptions struct {
+	LiveProgress bool `yaml:"live_progress,omitempty"` // send one message per invocation and edit it while the command runs
+	ThreadID     int  `yaml:"thread_id,omitempty"`     // topic of the forum chat
+	Silent       bool `yaml:"silent,omitempty"`        // deliver the messages without the sound
+}
+
+type OSCSequence string
+
+const (
+	OSC9    OSCSequence = "osc9"   // iTerm2, kitty, WezTerm, foot
+	OSC777  OSCSequence = "osc777" // notify extension of rxvt, WezTerm, foot, Konsole
+	OSCBell OSCSequence = "bell"   // terminal bell only, works everywhere the urgency hint or the visual bell is set up
+)
+
+type OSCOptions struct {
+	Sequence OSCSequence `yaml:"sequence,omitempty"` // osc9 by default
+}
+
+type QuietAction string
+
+const (
+	QuietSuppress QuietAction = "suppress" // drop notifications outside of the active hours
+	QuietDefer    QuietAction = "defer"    // deliver them when the active hours start
+	QuietReroute  QuietAction = "reroute"  // send them with another notifier instance
+)
+
+type Schedule struct {
+	Timezone    string          `yaml:"timezone,omitempty"` // IANA name, local time zone if empty
+	Active      []ScheduleRange `yaml:"active"`
+	QuietAction QuietAction     `yaml:"quiet_action,omitempty"` // suppress by default
+	RerouteTo   string          `yaml:"reroute_to,omitempty"`   // name of the notifier instance for reroute action
+}
+
+type ScheduleRange struct {
+	Days []string `yaml:"days,omitempty"` // mon, tue, ..., sun. Every day if empty
+	From string   `yaml:"from"`           // 08:00
+	To   string   `yaml:"to"`             // 22:00, range wraps the midnight if less than from
+}
+
+// NotificationPolicy limits the flow of notifications sent by the notifier instance
+type NotificationPolicy struct {
+	RateLimit   *RateLimitPolicy `yaml:"rate_limit,omitempty"`
+	DedupWindow *Duration        `yaml:"dedup_window,omitempty"` // drop notification if the same command has finished within the window
+	Digest      *DigestPolicy    `yaml:"digest,omitempty"`
+}
+
+type RateLimitPolicy struct {
+	Max    int      `yaml:"max"` // max number of notifications sent within the window, the rest are dropped
+	Window Duration `yaml:"window"`
+}
+
+type DigestPolicy struct {
+	Threshold int      `yaml:"threshold"` // notifications above the threshold within the window are batched into a single digest
+	Window    Duration `yaml:"window"`
+}
+
+func (p *NotificationPolicy) Empty() bool {
+	return p.RateLimit == nil && p.DedupWindow == nil && p.Digest == nil
+}
+
+// ID returns the name the notifier instance is registered with
+func (n *Notification) ID() string {
+	if len(n.Name) != 0 {
+		return n.Name
+	}
+	return string(n.Type)
 }
 
 type NotifierInitMode int
@@ -59,23 +153,79 @@ const (
 )
 
 type ShellTrackerConfig struct {
-	DirPath             string           `yaml:"dir_path"`                    // directory to store shell invocations
-	CleanupEnabled      bool             `yaml:"cleanup_enabled"`             // if enabled service will manually delete the invocations
-	TrackProcsBanList   []string         `yaml:"track_procs_ban_list"`        // do not track the binaries from the list
-	TrackProcsAllowList []string         `yaml:"track_procs_allow_list"`      // track only the binaries from the list
-	RPCSocketName       string           `yaml:"rpc_socket_name"`             // unix socket to use while running in client-server mode
-	DeadlineSec         int64            `yaml:"deadline_sec"`                // max time to await for notifier to finish its execution
-	Notifications       []Notification   `yaml:"notifications"`               // list of notifications and conditions for them
-	NotifierSettings    NotifierSettings `yaml:"notifier_settings,omitempty"` // notifier-specific params (non confidential)
+	DirPath             string           `yaml:"dir_path"`                        // directory to store shell invocations
+	CleanupEnabled      bool             `yaml:"cleanup_enabled"`                 // if enabled service will manually delete the invocations
+	TrackProcsBanList   []string         `yaml:"track_procs_ban_list"`            // do not track the binaries from the list
+	TrackProcsAllowList []string         `yaml:"track_procs_allow_list"`          // track only the binaries from the list
+	RPCSocketName       string           `yaml:"rpc_socket_name"`                 // unix socket to use while running in client-server mode
+	DeadlineSec         int64            `yaml:"deadline_sec"`                    // max time to await for notifier to finish its execution
+	Notifications       []Notification   `yaml:"notifications"`                   // list of notifications and conditions for them
+	NotifierSettings    NotifierSettings `yaml:"notifier_settings,omitempty"`     // notifier-specific params, credentials are set with secret references
+	RPCListenTCP        string           `yaml:"rpc_listen_tcp,omitempty"`        // additional tcp address to accept invocations forwarded by other daemons
+	RPCToken            Secret           `yaml:"rpc_token,omitempty"`             // token the forwarding daemons present on rpc_listen_tcp, required for non-loopback addresses
+	Upstream            *UpstreamConfig  `yaml:"upstream,omitempty"`              // forward invocations to the hub daemon instead of notifying locally
+	OrphanCheckInterval Duration         `yaml:"orphan_check_interval,omitempty"` // how often to check that the shells of pending invocations are alive
+	OutputTail          OutputTailConfig `yaml:"output_tail,omitempty"`           // output capturing of the commands executed with 'shnotify run'
+	RedactPatterns      []string         `yaml:"redact_patterns,omitempty"`       // extra regular expressions of the secrets to mask before sending anything out
+	Outbox              OutboxConfig     `yaml:"outbox,omitempty"`                // delivery of the async notifications
+	Capture             CaptureConfig    `yaml:"capture,omitempty"`               // optional parts of the invocation context to collect
+	ConfigPollInterval  *Duration        `yaml:"config_poll_interval,omitempty"`  // reload the daemon when the config file changes, checked with the period
+	HistorySize         int              `yaml:"history_size,omitempty"`          // number of the finished invocations kept for the history and stats, 1000 if not set
+	HookBudget          Duration         `yaml:"hook_budget,omitempty"`           // max time the shell hooks wait for the daemon before spooling the event, 200ms if not set
+	Standalone          bool             `yaml:"standalone,omitempty"`            // client tracks the commands in-process instead of talking to the daemon
+	ShutdownGracePeriod Duration         `yaml:"shutdown_grace_period,omitempty"` // time to finish the requests and deliveries in flight on stop, 5s if not set
 
 	InitMode           NotifierInitMode `yaml:"-"` // create all notifiers at the startup of the application or at the firt invocation of the notifier
 	AsyncNotifications bool             `yaml:"-"` // publish notification in a sync or async way
+	BackgroundTasks    bool             `yaml:"-"` // run timers watching over the pending invocations (long living process only)
 
 	// TODO garbage collection settings
 }
 
+// CaptureConfig enables collection of the invocation context parts which are not captured by default
+type CaptureConfig struct {
+	Git         bool     `yaml:"git,omitempty"`          // repository root and branch of the working directory
+	VirtualEnv  bool     `yaml:"virtual_env,omitempty"`  // active python virtual environment
+	KubeContext bool     `yaml:"kube_context,omitempty"` // current kubernetes context
+	Env         []string `yaml:"env,omitempty"`          // names (or glob patterns) of the environment variables
+}
+
+type OutputTailConfig struct {
+	Lines int `yaml:"lines,omitempty"` // number of the last output lines to attach to the notification
+	Bytes int `yaml:"bytes,omitempty"` // max size of the attached output
+}
+
+type OutboxConfig struct {
+	DirPath        string   `yaml:"dir_path,omitempty"`        // directory to persist notifications until delivery, <dir_path>/outbox by default
+	Workers        int      `yaml:"workers,omitempty"`         // number of parallel deliveries
+	MaxAttempts    int      `yaml:"max_attempts,omitempty"`    // notification is moved to the dead letters after this number of failures
+	InitialBackoff Duration `yaml:"initial_backoff,omitempty"` // delay after the first failure, doubled after each next one
+	MaxBackoff     Duration `yaml:"max_backoff,omitempty"`
+}
+
+type UpstreamConfig struct {
+	Network       string   `yaml:"network"`         // unix or tcp
+	Address       string   `yaml:"address"`         // socket path or host:port of the hub daemon
+	QueueDir      string   `yaml:"queue_dir"`       // directory of the durable queue for events not yet accepted by the hub
+	RetryInterval Duration `yaml:"retry_interval"`  // delay between delivery attempts while the hub is unavailable
+	Token         Secret   `yaml:"token,omitempty"` // rpc_token of the hub daemon
+}
+
+// Secret is the reference to the credential resolved when the notifier is created:
+// env:NAME, file:/path, cmd:<shell command> or keyring:<name>. Values are never put into the config.
+type Secret string
+
 type NotifierSettings struct {
-	TelegramChatID int64 `yaml:"telegram_chat_id,omitempty"`
+	TelegramChatID int64              `yaml:"telegram_chat_id,omitempty"`
+	TelegramToken  Secret             `yaml:"telegram_token,omitempty"`   // file:~/.config/shnotify/.tg.token if not set
+	TelegramAPIURL string             `yaml:"telegram_api_url,omitempty"` // Bot API server, https://api.telegram.org if not set
+	TelegramBot    *TelegramBotConfig `yaml:"telegram_bot,omitempty"`     // answer the commands sent to the bot in the chat
+}
+
+// TelegramBotConfig enables the commands of the bot polled by the daemon
+type TelegramBotConfig struct {
+	AllowedUsers []int64  `yaml:"allowed_users"`          // ids of the users allowed to send the commands
+	PollTimeout  Duration `yaml:"poll_timeout,omitempty"` // timeout of the long polling request, 30s if not set
 }
 
 func DefaultShellTrackerConfig() *ShellTrackerConfig {
@@ -89,6 +239,10 @@ func DefaultShellTrackerConfig() *ShellTrackerConfig {
 		DeadlineSec:    3,
 		CleanupEnabled: true,
 		RPCSocketName:  "/tmp/shnotify-rpc.sock",
+		OutputTail: OutputTailConfig{
+			Lines: 20,
+			Bytes: 4096,
+		},
 		Notifications: []Notification{
 			{
 				Type: types.NotificationCLI,
@@ -101,13 +255,31 @@ func DefaultShellTrackerConfig() *ShellTrackerConfig {
 	}
 }
 
+// PIDFilePath returns the file locked by the running daemon, it holds the pid of the daemon
+func (cfg *ShellTrackerConfig) PIDFilePath() string {
+	return path.Join(cfg.DirPath, "shnotifyd.pid")
+}
+
+// LoopbackAddress reports whether the tcp host:port accepts the connections from the local machine only
+func LoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (cfg *ShellTrackerConfig) Save(filePath string) error {
 	dirPath := path.Dir(filePath)
 	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
 		return err
 	}
 
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
 	if err != nil {
 		return err
 	}
@@ -119,22 +291,31 @@ func (cfg *ShellTrackerConfig) Save(filePath string) error {
 	return encoder.Encode(cfg)
 }
 
-func SaveConfigToDefaultLoc(cfg *ShellTrackerConfig) error {
+// DefaultLocation returns the path of the config file in the user config directory
+func DefaultLocation() (string, error) {
 	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, "shnotify", "config.yaml"), nil
+}
+
+func SaveConfigToDefaultLoc(cfg *ShellTrackerConfig) error {
+	filePath, err := DefaultLocation()
 	if err != nil {
 		return err
 	}
 
-	return cfg.Save(path.Join(dir, "shnotify", "config.yaml"))
+	return cfg.Save(filePath)
 }
 
 func ReadFromDefaultLoc() (*ShellTrackerConfig, error) {
-	dir, err := os.UserConfigDir()
+	filePath, err := DefaultLocation()
 	if err != nil {
 		return nil, err
 	}
 
-	reader, err := os.Open(path.Join(dir, "shnotify", "config.yaml"))
+	reader, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SystemLocation  = "/etc/shnotify/config.yaml"
+	ProjectFileName = ".shnotify.yaml"
+
+	ConfigPathEnv = "SHNOTIFY_CONFIG" // replaces the user config like --config flag
+	envPrefix     = "SHNOTIFY_"
+	EnvSource     = "environment"
+)
+
+// settings the project config is allowed to change, the rest of them belong to the machine and the user
+var projectKeys = []string{"notifications", "capture", "redact_patterns", "output_tail"}
+
+// nested settings of projectKeys the project still cannot change: a checked out repository
+// must not send the environment or the files of the user with the notifications
+var projectDeniedKeys = []string{"capture.env", "notifications.template_file"}
+
+func isProjectKey(key string) bool {
+	return slices.Contains(projectKeys, key)
+}
+
+// Locations returns the machine wide config files in the order of application: system and user (or explicit) one
+func Locations(explicitPath string) ([]string, error) {
+	userPath := explicitPath
+	if len(userPath) == 0 {
+		userPath = os.Getenv(ConfigPathEnv)
+	}
+	if len(userPath) == 0 {
+		var err error
+		if userPath, err = DefaultLocation(); err != nil {
+			return nil, err
+		}
+	}
+	return []string{SystemLocation, userPath}, nil
+}
+
+// FindProject looks for the project config in the directory and its parents
+func FindProject(dir string) (string, bool) {
+	for {
+		candidate := filepath.Join(dir, ProjectFileName)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Load merges the existing config layers: system, user (or explicit) and the project one found from the project dir
+// (skipped if empty), then applies SHNOTIFY_* environment overrides. Defaults are used if there are no config files.
+// Sources are the files and the environment the config was built from.
+func Load(explicitPath, projectDir string) (*ShellTrackerConfig, []string, error) {
+	paths, err := Locations(explicitPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		merged  *yaml.Node
+		sources []string
+	)
+	for _, filePath := range paths {
+		layer, err := ReadLayer(filePath)
+		if errors.Is(err, os.ErrNotExist) && filePath != explicitPath {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		merged = mergeNodes(merged, layer, "")
+		sources = append(sources, filePath)
+	}
+
+	if merged == nil {
+		merged = &yaml.Node{}
+		if err := merged.Encode(DefaultShellTrackerConfig()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(projectDir) != 0 {
+		if projectPath, ok := FindProject(projectDir); ok {
+			layer, err := ReadLayer(projectPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			merged = mergeNodes(merged, projectLayer(layer), "")
+			sources = append(sources, projectPath)
+		}
+	}
+
+	if overrides, _ := envOverrides(os.Environ()); overrides != nil {
+		merged = mergeNodes(merged, overrides, "")
+		sources = append(sources, EnvSource)
+	}
+
+	var cfg ShellTrackerConfig
+	if err := merged.Decode(&cfg); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, sources, nil
+}
+
+// WithProject returns the copy of the config extended with the project layer.
+// Settings the project is not allowed to change are ignored.
+func WithProject(cfg *ShellTrackerConfig, projectPath string) (*ShellTrackerConfig, error) {
+	layer, err := ReadLayer(projectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var base yaml.Node
+	if err := base.Encode(cfg); err != nil {
+		return nil, err
+	}
+
+	var ret ShellTrackerConfig
+	if err := mergeNodes(&base, projectLayer(layer), "").Decode(&ret); err != nil {
+		return nil, fmt.Errorf("%s: %w", projectPath, err)
+	}
+
+	// runtime parameters are not serialized
+	ret.InitMode = cfg.InitMode
+	ret.AsyncNotifications = cfg.AsyncNotifications
+	ret.BackgroundTasks = cfg.BackgroundTasks
+	return &ret, nil
+}
+
+// ReadLayer parses the config file, errors are reported with the path of the file
+func ReadLayer(filePath string) (*yaml.Node, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(raw, &node); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	if len(node.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	// decoding of the layer alone gives errors with the lines of this file
+	var cfg ShellTrackerConfig
+	if err := node.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return node.Content[0], nil
+}
+
+// projectLayer drops the settings the project is not allowed to change
+func projectLayer(layer *yaml.Node) *yaml.Node {
+	filtered, _ := filterProject(layer, "")
+	return filtered
+}
+
+// ProjectIgnoredKeys returns the key nodes of the project layer which are dropped on load
+func ProjectIgnoredKeys(layer *yaml.Node) []*yaml.Node {
+	_, ignored := filterProject(layer, "")
+	return ignored
+}
+
+// filterProject copies the node of the dotted key path without the settings the project cannot change,
+// the items of the lists share the path of the list
+func filterProject(node *yaml.Node, keyPath string) (*yaml.Node, []*yaml.Node) {
+	var ignored []*yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		filtered := *node
+		filtered.Content = nil
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			childPath := key.Value
+			if len(keyPath) != 0 {
+				childPath = keyPath + "." + key.Value
+			}
+			if (len(keyPath) == 0 && !isProjectKey(key.Value)) || slices.Contains(projectDeniedKeys, childPath) {
+				ignored = append(ignored, key)
+				continue
+			}
+			child, childIgnored := filterProject(node.Content[i+1], childPath)
+			filtered.Content = append(filtered.Content, key, child)
+			ignored = append(ignored, childIgnored...)
+		}
+		return &filtered, ignored
+	case yaml.SequenceNode:
+		filtered := *node
+		filtered.Content = make([]*yaml.Node, 0, len(node.Content))
+		for _, item := range node.Content {
+			child, childIgnored := filterProject(item, keyPath)
+			filtered.Content = append(filtered.Content, child)
+			ignored = append(ignored, childIgnored...)
+		}
+		return &filtered, ignored
+	default:
+		return node, nil
+	}
+}
+
+// envOverrides builds the layer from SHNOTIFY_* variables, nested keys are separated with double underscore:
+// SHNOTIFY_DEADLINE_SEC=5, SHNOTIFY_NOTIFIER_SETTINGS__TELEGRAM_CHAT_ID=123. Only scalar settings can be set.
+// Variables which do not match any setting are skipped and returned as errors.
+func envOverrides(environ []string) (*yaml.Node, []error) {
+	var (
+		root *yaml.Node
+		errs []error
+	)
+	for _, kv := range environ {
+		name, val, _ := strings.Cut(kv, "=")
+		key, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || name == ConfigPathEnv || len(key) == 0 {
+			continue
+		}
+
+		override := &yaml.Node{Kind: yaml.ScalarNode, Value: val}
+		keys := strings.Split(strings.ToLower(key), "__")
+		for i := len(keys) - 1; i >= 0; i-- {
+			override = &yaml.Node{
+				Kind:    yaml.MappingNode,
+				Tag:     "!!map",
+				Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: keys[i]}, override},
+			}
+		}
+
+		if err := probeDecode(override); err != nil {
+			var typeErr *yaml.TypeError
+			if errors.As(err, &typeErr) && len(typeErr.Errors) != 0 {
+				// the probe is the single line document
+				err = errors.New(strings.TrimPrefix(typeErr.Errors[0], "line 1: "))
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		root = mergeNodes(root, override, "")
+	}
+	return root, errs
+}
+
+// InvalidEnvOverrides returns the errors of SHNOTIFY_* variables ignored by Load
+func InvalidEnvOverrides() []error {
+	_, errs := envOverrides(os.Environ())
+	return errs
+}
+
+// probeDecode checks that the node has known fields only and valid values
+func probeDecode(node *yaml.Node) error {
+	var encoded bytes.Buffer
+	if err := yaml.NewEncoder(&encoded).Encode(node); err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(&encoded)
+	decoder.KnownFields(true)
+	var probe ShellTrackerConfig
+	return decoder.Decode(&probe)
+}
+
+// mergeNodes applies the layer on top of the base without modification of them:
+// mappings are merged key by key, scalars are replaced, notifications are merged by the notifier name
+// and the items of other lists are appended skipping duplicates
+func mergeNodes(base, layer *yaml.Node, key string) *yaml.Node {
+	if base == nil {
+		return layer
+	}
+	if base.Kind == yaml.DocumentNode && len(base.Content) != 0 {
+		base = base.Content[0]
+	}
+	if layer.Kind == yaml.DocumentNode && len(layer.Content) != 0 {
+		layer = layer.Content[0]
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && layer.Kind == yaml.MappingNode:
+		merged := *base
+		merged.Content = slices.Clone(base.Content)
+		for i := 0; i+1 < len(layer.Content); i += 2 {
+			k, v := layer.Content[i], layer.Content[i+1]
+			idx := mappingIndex(&merged, k.Value)
+			if idx < 0 {
+				merged.Content = append(merged.Content, k, v)
+				continue
+			}
+			merged.Content[idx+1] = mergeNodes(merged.Content[idx+1], v, k.Value)
+		}
+		return &merged
+
+	case base.Kind == yaml.SequenceNode && layer.Kind == yaml.SequenceNode:
+		merged := *base
+		merged.Content = slices.Clone(base.Content)
+		for _, item := range layer.Content {
+			var idx int
+			if key == "notifications" {
+				idx = slices.IndexFunc(merged.Content, func(n *yaml.Node) bool { return NotifierID(n) == NotifierID(item) })
+			} else {
+				idx = slices.IndexFunc(merged.Content, func(n *yaml.Node) bool {
+					return n.Kind == yaml.ScalarNode && item.Kind == yaml.ScalarNode && n.Value == item.Value
+				})
+			}
+			switch {
+			case idx < 0:
+				merged.Content = append(merged.Content, item)
+			case key == "notifications":
+				merged.Content[idx] = mergeNodes(merged.Content[idx], item, "")
+			}
+		}
+		return &merged
+
+	default:
+		return layer
+	}
+}
+
+// mappingIndex returns the position of the key in the content of the mapping node, -1 if it is absent
+func mappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// NotifierID returns the name of the notification entry node, its type if the name is not set
+func NotifierID(node *yaml.Node) string {
+	var name, typ string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch node.Content[i].Value {
+		case "name":
+			name = node.Content[i+1].Value
+		case "type":
+			typ = node.Content[i+1].Value
+		}
+	}
+	if len(name) != 0 {
+		return name
+	}
+	return typ
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseNode(t *testing.T, raw string) *yaml.Node {
+	t.Helper()
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(raw), &node); err != nil {
+		t.Fatalf("invalid yaml %q: %v", raw, err)
+	}
+	return &node
+}
+
+// plain decodes the node for the comparison regardless of the formatting
+func plain(t *testing.T, node *yaml.Node) map[string]any {
+	t.Helper()
+	var ret map[string]any
+	if err := node.Decode(&ret); err != nil {
+		t.Fatalf("failed to decode node: %v", err)
+	}
+	return ret
+}
+
+func TestMergeNodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		base, layer string
+		want        string
+	}{
+		{
+			name:  "scalars are replaced",
+			base:  "deadline_sec: 3\ndir_path: /tmp/a",
+			layer: "deadline_sec: 5",
+			want:  "deadline_sec: 5\ndir_path: /tmp/a",
+		},
+		{
+			name:  "mappings are merged by key",
+			base:  "notifier_settings: {telegram_chat_id: 1, telegram_token: env:TG}",
+			layer: "notifier_settings: {telegram_chat_id: 2}\noutbox: {workers: 4}",
+			want:  "notifier_settings: {telegram_chat_id: 2, telegram_token: env:TG}\noutbox: {workers: 4}",
+		},
+		{
+			name:  "notifications are merged by name",
+			base:  "notifications: [{name: work, type: telegram, conditions: {run_longer_than: 10s}}, {type: cli}]",
+			layer: "notifications: [{name: work, conditions: {exit_codes: [1]}}, {name: home, type: os-push}]",
+			want: "notifications: [{name: work, type: telegram, conditions: {run_longer_than: 10s, exit_codes: [1]}}, " +
+				"{type: cli}, {name: home, type: os-push}]",
+		},
+		{
+			name:  "notifications without name are merged by type",
+			base:  "notifications: [{type: cli, conditions: {run_longer_than: 10s}}]",
+			layer: "notifications: [{type: cli, conditions: {run_longer_than: 1m}}]",
+			want:  "notifications: [{type: cli, conditions: {run_longer_than: 1m}}]",
+		},
+		{
+			name:  "other lists are appended without duplicates",
+			base:  "track_procs_ban_list: [vim, less]",
+			layer: "track_procs_ban_list: [less, htop]",
+			want:  "track_procs_ban_list: [vim, less, htop]",
+		},
+		{
+			name:  "list replaces scalar",
+			base:  "redact_patterns: ''",
+			layer: "redact_patterns: [token]",
+			want:  "redact_patterns: [token]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := parseNode(t, tt.base)
+			before := plain(t, base)
+
+			merged := mergeNodes(base, parseNode(t, tt.layer), "")
+			if got, want := plain(t, merged), plain(t, parseNode(t, tt.want)); !reflect.DeepEqual(got, want) {
+				t.Errorf("merged to %v, expected %v", got, want)
+			}
+			if after := plain(t, base); !reflect.DeepEqual(after, before) {
+				t.Errorf("base is modified by the merge: %v, was %v", after, before)
+			}
+		})
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		environ  []string
+		want     string // empty if there are no overrides
+		wantErrs int
+	}{
+		{
+			name:    "top level setting",
+			environ: []string{"SHNOTIFY_DEADLINE_SEC=5"},
+			want:    "deadline_sec: 5",
+		},
+		{
+			name:    "nested setting",
+			environ: []string{"SHNOTIFY_NOTIFIER_SETTINGS__TELEGRAM_CHAT_ID=123", "SHNOTIFY_OUTBOX__WORKERS=4"},
+			want:    "notifier_settings: {telegram_chat_id: 123}\noutbox: {workers: 4}",
+		},
+		{
+			name:    "other variables are skipped",
+			environ: []string{"HOME=/root", "SHNOTIFY_CONFIG=/tmp/config.yaml", "SHNOTIFY_=1", "XSHNOTIFY_DEADLINE_SEC=5"},
+		},
+		{
+			name:     "unknown setting",
+			environ:  []string{"SHNOTIFY_NO_SUCH_SETTING=1", "SHNOTIFY_DEADLINE_SEC=5"},
+			want:     "deadline_sec: 5",
+			wantErrs: 1,
+		},
+		{
+			name:     "invalid value",
+			environ:  []string{"SHNOTIFY_DEADLINE_SEC=soon", "SHNOTIFY_HOOK_BUDGET=forever"},
+			wantErrs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, errs := envOverrides(tt.environ)
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got errors %v, expected %d", errs, tt.wantErrs)
+			}
+			if len(tt.want) == 0 {
+				if node != nil {
+					t.Errorf("unexpected overrides %v", plain(t, node))
+				}
+				return
+			}
+			if node == nil {
+				t.Fatalf("no overrides, expected %s", tt.want)
+			}
+			if got, want := plain(t, node), plain(t, parseNode(t, tt.want)); !reflect.DeepEqual(got, want) {
+				t.Errorf("overrides %v, expected %v", got, want)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, filePath, content string) {
+	t.Helper()
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", filePath, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if _, err := os.Stat(SystemLocation); err == nil {
+		t.Skipf("%s exists and would be merged into the result", SystemLocation)
+	}
+
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, userPath, `
+dir_path: /tmp/shnotify
+deadline_sec: 3
+notifications:
+  - type: cli
+    conditions: {run_longer_than: 10s}
+`)
+	projectDir := filepath.Join(dir, "project", "src")
+	if err := os.MkdirAll(projectDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	projectPath := filepath.Join(dir, "project", ProjectFileName)
+	writeFile(t, projectPath, `
+dir_path: /tmp/project # belongs to the machine, ignored
+capture:
+  git: true
+  env: [AWS_*] # ignored
+notifications:
+  - type: cli
+    conditions: {run_longer_than: 1m}
+    template_file: /etc/passwd # ignored
+`)
+	t.Setenv("SHNOTIFY_DEADLINE_SEC", "7")
+
+	cfg, sources, err := Load(userPath, projectDir)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if want := []string{userPath, projectPath, EnvSource}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("sources %v, expected %v", sources, want)
+	}
+	if cfg.DirPath != "/tmp/shnotify" {
+		t.Errorf("dir_path %s is changed by the project", cfg.DirPath)
+	}
+	if cfg.DeadlineSec != 7 {
+		t.Errorf("deadline_sec %d is not overridden by the environment", cfg.DeadlineSec)
+	}
+	if len(cfg.Notifications) != 1 || cfg.Notifications[0].Conditions.RunLongerThan.LessThan(60) {
+		t.Errorf("notification is not merged with the project one: %+v", cfg.Notifications)
+	}
+	if len(cfg.Notifications) == 1 && len(cfg.Notifications[0].TemplateFile) != 0 {
+		t.Errorf("template_file %s is set by the project", cfg.Notifications[0].TemplateFile)
+	}
+	if !cfg.Capture.Git || len(cfg.Capture.Env) != 0 {
+		t.Errorf("capture %+v, expected git only", cfg.Capture)
+	}
+}
+
+func TestLoadExplicitMissing(t *testing.T) {
+	if _, _, err := Load(filepath.Join(t.TempDir(), "absent.yaml"), ""); err == nil {
+		t.Errorf("missing explicit config is not reported")
+	}
+}
@@ -0,0 +1,108 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/notify/render"
+)
+
+const (
+	durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	secretPattern   = `^(env|file|cmd|keyring):.+$`
+)
+
+// JSONSchema returns the schema of the config file for editors (e.g. yaml-language-server), generated from the config types
+func JSONSchema() ([]byte, error) {
+	root := schemaOf(reflect.TypeFor[config.ShellTrackerConfig]())
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "shnotify config"
+	return json.MarshalIndent(root, "", "  ")
+}
+
+func enums() map[reflect.Type][]string {
+	var notifierTypes []string
+	for _, t := range core.SupportedNotificationTypes() {
+		notifierTypes = append(notifierTypes, string(t))
+	}
+	return map[reflect.Type][]string{
+		reflect.TypeFor[config.QuietAction](): {
+			string(config.QuietSuppress),
+			string(config.QuietDefer),
+			string(config.QuietReroute),
+		},
+		reflect.TypeFor[config.OSCSequence](): {
+			string(config.OSC9),
+			string(config.OSC777),
+			string(config.OSCBell),
+		},
+		reflect.TypeOf(config.Notification{}.Type): notifierTypes,
+	}
+}
+
+// string fields with the fixed set of values which are not declared as separate types
+var fieldEnums = map[string][]string{
+	"format": {
+		string(render.FormatPlain),
+		string(render.FormatMarkdown),
+		string(render.FormatMarkdownV2),
+		string(render.FormatHTML),
+	},
+	"network": {"unix", "tcp"},
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeFor[config.Duration]() {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+	if t == reflect.TypeFor[config.Secret]() {
+		return map[string]any{"type": "string", "pattern": secretPattern}
+	}
+	if values, ok := enums()[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || name == "-" || len(name) == 0 {
+				continue
+			}
+			prop := schemaOf(field.Type)
+			if values, ok := fieldEnums[name]; ok && prop["type"] == "string" {
+				prop["enum"] = values
+			}
+			props[name] = prop
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
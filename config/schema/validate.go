@@ -0,0 +1,481 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/notify/telegram"
+	"github.com/oclaw/shnotify/schedule"
+	"github.com/oclaw/shnotify/secrets"
+	"github.com/oclaw/shnotify/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is the issue of the config found by validation, line is 0 if the position is not known
+type Problem struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+
+	path []any // keys of the setting, used to find it in the config layers
+}
+
+// FileProblems are the problems found in one of the config layers
+type FileProblems struct {
+	Path     string
+	Problems []Problem
+}
+
+// notifierID is the path step selecting the notification entry by its name
+type notifierID string
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// Error joins the problems into the single error, nil if there are none
+func Error(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(problems))
+	for _, p := range problems {
+		errs = append(errs, errors.New(p.String()))
+	}
+	return errors.Join(errs...)
+}
+
+// LayersError joins the problems of the config layers into the single error, nil if there are none
+func LayersError(files []FileProblems) error {
+	var errs []error
+	for _, f := range files {
+		for _, p := range f.Problems {
+			errs = append(errs, fmt.Errorf("%s: %s", f.Path, p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateFile checks the config file, error is returned if it cannot be read
+func ValidateFile(filePath string) ([]Problem, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(raw), nil
+}
+
+var lineRe = regexp.MustCompile(`line (\d+): `)
+
+// Validate reports syntax errors, unknown fields, malformed values and semantic issues of the config
+func Validate(raw []byte) []Problem {
+	cfg, root, problems := parse(raw)
+	if len(problems) != 0 {
+		return problems
+	}
+	return sortProblems(Check(cfg, root))
+}
+
+// ValidateLayers checks every existing config layer on its own and the semantics of the merged config.
+// Semantic problems are attributed to the layer defining the setting.
+func ValidateLayers(explicitPath, projectDir string) ([]FileProblems, error) {
+	paths, err := config.Locations(explicitPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(projectDir) != 0 {
+		if projectPath, ok := config.FindProject(projectDir); ok {
+			paths = append(paths, projectPath)
+		}
+	}
+
+	type layer struct {
+		path string
+		root *yaml.Node
+	}
+	var (
+		layers []layer
+		ret    []FileProblems
+	)
+	for i, filePath := range paths {
+		raw, err := os.ReadFile(filePath)
+		if errors.Is(err, os.ErrNotExist) && filePath != explicitPath {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, root, problems := parse(raw)
+		if isProject := i >= 2; isProject && root != nil {
+			for _, key := range config.ProjectIgnoredKeys(root) {
+				problems = append(problems, Problem{
+					Line:    key.Line,
+					Message: fmt.Sprintf("'%s' cannot be set in the project config, it is ignored", key.Value),
+				})
+			}
+		}
+		if len(problems) != 0 {
+			ret = append(ret, FileProblems{Path: filePath, Problems: sortProblems(problems)})
+		}
+		layers = append(layers, layer{filePath, root})
+	}
+
+	var envProblems []Problem
+	for _, err := range config.InvalidEnvOverrides() {
+		envProblems = append(envProblems, Problem{Message: fmt.Sprintf("ignored override %v", err)})
+	}
+	withEnv := func(files []FileProblems) []FileProblems {
+		if len(envProblems) == 0 {
+			return files
+		}
+		return append(files, FileProblems{Path: config.EnvSource, Problems: envProblems})
+	}
+
+	if len(ret) != 0 {
+		// semantic checks of the broken layers give misleading results
+		return withEnv(ret), nil
+	}
+
+	cfg, _, err := config.Load(explicitPath, projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := make(map[string][]Problem)
+	var order []string
+	for _, p := range Check(cfg, nil) {
+		filePath, bestDepth := "effective config", 0
+		for _, l := range layers {
+			line, depth := locateDepth(l.root, p.path...)
+			if depth > 0 && depth >= bestDepth {
+				filePath, bestDepth, p.Line = l.path, depth, line
+			}
+		}
+		if _, ok := byPath[filePath]; !ok {
+			order = append(order, filePath)
+		}
+		byPath[filePath] = append(byPath[filePath], p)
+	}
+	for _, filePath := range order {
+		ret = append(ret, FileProblems{Path: filePath, Problems: sortProblems(byPath[filePath])})
+	}
+	return withEnv(ret), nil
+}
+
+// parse decodes the config reporting syntax errors, unknown fields and malformed values
+func parse(raw []byte) (*config.ShellTrackerConfig, *yaml.Node, []Problem) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return nil, nil, []Problem{problemFromErr(err.Error())}
+	}
+	if len(root.Content) == 0 {
+		return nil, nil, []Problem{{Message: "config is empty"}}
+	}
+
+	var cfg config.ShellTrackerConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, root.Content[0], []Problem{problemFromErr(err.Error())}
+		}
+		problems := make([]Problem, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, problemFromErr(msg))
+		}
+		return nil, root.Content[0], sortProblems(problems)
+	}
+	return &cfg, root.Content[0], nil
+}
+
+func problemFromErr(msg string) Problem {
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	match := lineRe.FindStringSubmatchIndex(msg)
+	if match == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(msg[match[2]:match[3]])
+	return Problem{
+		Line:    line,
+		Message: msg[:match[0]] + msg[match[1]:],
+	}
+}
+
+func sortProblems(problems []Problem) []Problem {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// Check reports semantic issues of the decoded config, node is used to find their positions and may be nil
+func Check(cfg *config.ShellTrackerConfig, node *yaml.Node) []Problem {
+	c := &checker{node: node}
+
+	if len(cfg.DirPath) == 0 {
+		c.report("dir_path is not set", "dir_path")
+	}
+	if len(cfg.RPCSocketName) == 0 {
+		c.report("rpc_socket_name is not set", "rpc_socket_name")
+	}
+	if cfg.DeadlineSec <= 0 {
+		c.report("deadline_sec must be positive", "deadline_sec")
+	}
+	if cfg.OutputTail.Lines < 0 || cfg.OutputTail.Bytes < 0 {
+		c.report("output_tail limits must not be negative", "output_tail")
+	}
+	for i, pattern := range cfg.RedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			c.report(fmt.Sprintf("invalid redact pattern: %v", err), "redact_patterns", i)
+		}
+	}
+	for i, pattern := range cfg.Capture.Env {
+		if _, err := path.Match(pattern, ""); err != nil {
+			c.report(fmt.Sprintf("invalid env pattern '%s'", pattern), "capture", "env", i)
+		}
+	}
+	if cfg.Outbox.Workers < 0 || cfg.Outbox.MaxAttempts < 0 {
+		c.report("outbox workers and max_attempts must not be negative", "outbox")
+	}
+
+	if _, err := telegram.ParseAPIURL(cfg.NotifierSettings.TelegramAPIURL); err != nil {
+		c.report(err.Error(), "notifier_settings", "telegram_api_url")
+	}
+	if tgBot := cfg.NotifierSettings.TelegramBot; tgBot != nil {
+		if cfg.NotifierSettings.TelegramChatID == 0 {
+			c.report("telegram bot requires notifier_settings.telegram_chat_id", "notifier_settings", "telegram_bot")
+		}
+		if len(tgBot.AllowedUsers) == 0 {
+			c.report("telegram bot accepts commands from the allowed users only, set allowed_users", "notifier_settings", "telegram_bot", "allowed_users")
+		}
+	}
+	if cfg.HistorySize < 0 {
+		c.report("history_size must not be negative", "history_size")
+	}
+	if cfg.HookBudget < 0 {
+		c.report("hook_budget must not be negative", "hook_budget")
+	}
+	if cfg.ShutdownGracePeriod < 0 {
+		c.report("shutdown_grace_period must not be negative", "shutdown_grace_period")
+	}
+	if ref := cfg.NotifierSettings.TelegramToken; len(ref) != 0 {
+		if _, _, err := secrets.Parse(ref); err != nil {
+			c.report(err.Error(), "notifier_settings", "telegram_token")
+		}
+	}
+
+	if len(cfg.RPCListenTCP) != 0 && len(cfg.RPCToken) == 0 && !config.LoopbackAddress(cfg.RPCListenTCP) {
+		c.report("rpc_token is required to listen on non-loopback address", "rpc_listen_tcp")
+	}
+	if ref := cfg.RPCToken; len(ref) != 0 {
+		if _, _, err := secrets.Parse(ref); err != nil {
+			c.report(err.Error(), "rpc_token")
+		}
+	}
+
+	if up := cfg.Upstream; up != nil {
+		switch up.Network {
+		case "", "unix", "tcp":
+		default:
+			c.report(fmt.Sprintf("unsupported upstream network '%s', unix or tcp expected", up.Network), "upstream", "network")
+		}
+		if len(up.Address) == 0 {
+			c.report("upstream address is not set", "upstream", "address")
+		}
+		if ref := up.Token; len(ref) != 0 {
+			if _, _, err := secrets.Parse(ref); err != nil {
+				c.report(err.Error(), "upstream", "token")
+			}
+		}
+	}
+
+	c.checkNotifications(cfg)
+	return c.problems
+}
+
+type checker struct {
+	node     *yaml.Node
+	problems []Problem
+}
+
+func (c *checker) report(msg string, at ...any) {
+	line, _ := locateDepth(c.node, at...)
+	c.problems = append(c.problems, Problem{
+		Line:    line,
+		Message: msg,
+		path:    at,
+	})
+}
+
+func (c *checker) checkNotifications(cfg *config.ShellTrackerConfig) {
+	supported := core.SupportedNotificationTypes()
+	names := make(map[string]struct{})
+
+	for i := range cfg.Notifications {
+		notif := &cfg.Notifications[i]
+		at := func(keys ...any) []any {
+			return append([]any{"notifications", notifierID(notif.ID())}, keys...)
+		}
+
+		switch {
+		case len(notif.Type) == 0:
+			c.report("notifier type is not set", at()...)
+		case !slices.Contains(supported, notif.Type):
+			c.report(fmt.Sprintf("unknown notifier type '%s', supported: %s", notif.Type, joinTypes(supported)), at("type")...)
+		case notif.Type == types.NotificationTelegram && cfg.NotifierSettings.TelegramChatID == 0:
+			c.report("telegram notifier requires notifier_settings.telegram_chat_id", at("type")...)
+		}
+
+		if _, ok := names[notif.ID()]; ok {
+			c.report(fmt.Sprintf("duplicate notifier name '%s', set unique names for the notifiers of the same type", notif.ID()), at()...)
+		}
+		names[notif.ID()] = struct{}{}
+
+		c.checkTemplate(notif, at)
+
+		cond := &notif.Conditions
+		if cond.Every != nil && cond.StillRunningAfter == nil {
+			c.report("'every' requires 'still_running_after'", at("conditions", "every")...)
+		}
+		if tg := notif.Telegram; tg != nil {
+			switch {
+			case notif.Type != types.NotificationTelegram:
+				c.report("telegram options are supported only by the telegram notifier", at("telegram")...)
+			case tg.LiveProgress && cond.StillRunningAfter == nil:
+				c.report("'live_progress' requires 'still_running_after'", at("telegram", "live_progress")...)
+			}
+			if tg.ThreadID < 0 {
+				c.report("thread_id must not be negative", at("telegram", "thread_id")...)
+			}
+		}
+		if osc := notif.OSC; osc != nil {
+			switch {
+			case notif.Type != types.NotificationOSC:
+				c.report("osc options are supported only by the osc notifier", at("osc")...)
+			case osc.Sequence != "" && osc.Sequence != config.OSC9 && osc.Sequence != config.OSC777 && osc.Sequence != config.OSCBell:
+				c.report(fmt.Sprintf("unknown osc sequence '%s', expected osc9, osc777 or bell", osc.Sequence), at("osc", "sequence")...)
+			}
+		}
+		for field := range cond.Context {
+			name, isEnv := strings.CutPrefix(field, "env.")
+			if _, known := (&types.InvocationContext{}).Field(field); !known && !(isEnv && len(name) != 0) {
+				c.report(fmt.Sprintf("unknown context field '%s'", field), at("conditions", "context", field)...)
+			}
+		}
+
+		policy := &notif.Policy
+		if rl := policy.RateLimit; rl != nil && (rl.Max <= 0 || rl.Window <= 0) {
+			c.report("rate_limit requires positive max and window", at("policy", "rate_limit")...)
+		}
+		if dg := policy.Digest; dg != nil && (dg.Threshold <= 0 || dg.Window <= 0) {
+			c.report("digest requires positive threshold and window", at("policy", "digest")...)
+		}
+
+		if sched := notif.Schedule; sched != nil {
+			if _, err := schedule.New(sched); err != nil {
+				c.report(err.Error(), at("schedule")...)
+			}
+			switch sched.QuietAction {
+			case "", config.QuietSuppress, config.QuietDefer:
+			case config.QuietReroute:
+				if !slices.ContainsFunc(cfg.Notifications, func(n config.Notification) bool { return n.ID() == sched.RerouteTo }) {
+					c.report(fmt.Sprintf("unknown reroute target '%s'", sched.RerouteTo), at("schedule", "reroute_to")...)
+				}
+			default:
+				c.report(fmt.Sprintf("unknown quiet action '%s', expected suppress, defer or reroute", sched.QuietAction), at("schedule", "quiet_action")...)
+			}
+		}
+	}
+}
+
+func (c *checker) checkTemplate(notif *config.Notification, at func(keys ...any) []any) {
+	format := render.FormatPlain
+	if len(notif.Format) != 0 {
+		var err error
+		if format, err = render.ParseFormat(notif.Format); err != nil {
+			c.report(err.Error(), at("format")...)
+			return
+		}
+	}
+
+	switch {
+	case len(notif.Template) != 0:
+		if _, err := render.New(notif.ID(), notif.Template, format); err != nil {
+			c.report(err.Error(), at("template")...)
+		}
+	case len(notif.TemplateFile) != 0:
+		if _, err := render.NewFromFile(notif.ID(), notif.TemplateFile, format); err != nil {
+			c.report(err.Error(), at("template_file")...)
+		}
+	}
+}
+
+func joinTypes(list []types.NotificationType) string {
+	names := make([]string, 0, len(list))
+	for _, t := range list {
+		names = append(names, string(t))
+	}
+	return strings.Join(names, ", ")
+}
+
+// locateDepth returns the line of the value at the path of mapping keys, sequence indexes and notifier names.
+// If the value is not present, line of the closest existing parent is returned. Depth is the number of found steps.
+func locateDepth(node *yaml.Node, path ...any) (int, int) {
+	if node == nil {
+		return 0, 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) != 0 {
+		node = node.Content[0]
+	}
+
+	line := node.Line
+	for depth, step := range path {
+		var next *yaml.Node
+		switch key := step.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return line, depth
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		case notifierID:
+			if node.Kind != yaml.SequenceNode {
+				return line, depth
+			}
+			for _, item := range node.Content {
+				if config.NotifierID(item) == string(key) {
+					next = item
+					break
+				}
+			}
+		}
+		if next == nil {
+			return line, depth
+		}
+		node, line = next, next.Line
+	}
+	return line, len(path)
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/types"
+)
+
+// conditionsMatch reports whether the notification configured with the conditions should be sent for the data
+func conditionsMatch(cond *config.NotificationConditions, data *types.NotificationData) bool {
+	if !contextMatch(cond.Context, data.Invocation) {
+		return false
+	}
+	if cond.OnlyWhenUnfocused && data.Focused {
+		return false
+	}
+
+	switch data.Kind {
+	case types.NotificationFinished:
+		return cond.RunLongerThan.LessThan(data.ExecTime)
+	case types.NotificationInProgress:
+		return cond.StillRunningAfter != nil
+	case types.NotificationAbandoned:
+		return cond.OnShellDied && (cond.RunLongerThan == nil || cond.RunLongerThan.LessThan(data.ExecTime))
+	default:
+		return false
+	}
+}
+
+// finalizesLive reports whether the notification is the result for the live message sent by the in-progress conditions,
+// the message is finalized even if the result does not match the conditions itself
+func finalizesLive(notif *config.Notification, data *types.NotificationData) bool {
+	if notif.Telegram == nil || !notif.Telegram.LiveProgress || notif.Conditions.StillRunningAfter == nil {
+		return false
+	}
+	if data.Kind != types.NotificationFinished && data.Kind != types.NotificationAbandoned {
+		return false
+	}
+	return data.Invocation.ProgressNotifiedBy(notif.ID()) != 0 &&
+		data.ExecTime >= durationSec(notif.Conditions.StillRunningAfter) &&
+		contextMatch(notif.Conditions.Context, data.Invocation)
+}
+
+// contextMatch reports whether the invocation context satisfies all the patterns
+func contextMatch(patterns map[string]string, rec *types.ShellInvocationRecord) bool {
+	for field, pattern := range patterns {
+		if rec == nil {
+			return false
+		}
+		val, ok := rec.Context.Field(field)
+		if !ok || !globMatch(pattern, val) {
+			return false
+		}
+	}
+	return true
+}
+
+func globMatch(pattern, val string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return val == dir || strings.HasPrefix(val, dir+"/")
+	}
+	matched, _ := path.Match(pattern, val)
+	return matched
+}
+
+// nextProgressDue returns the unix time of the next in-progress notification of the entry for the invocation.
+// Dues are counted from the invocation start so they are restored as is after the daemon restart.
+func nextProgressDue(notif *config.Notification, rec *types.ShellInvocationRecord) (int64, bool) {
+	cond := &notif.Conditions
+	if cond.StillRunningAfter == nil {
+		return 0, false
+	}
+
+	first := rec.Timestamp + durationSec(cond.StillRunningAfter)
+	notifiedAt := rec.ProgressNotifiedBy(notif.ID())
+	if notifiedAt < first {
+		return first, true
+	}
+
+	every := durationSec(cond.Every)
+	if every <= 0 {
+		return 0, false
+	}
+
+	passed := (notifiedAt-first)/every + 1
+	return first + passed*every, true
+}
+
+func durationSec(d *config.Duration) int64 {
+	if d == nil {
+		return 0
+	}
+	return int64(time.Duration(*d) / time.Second)
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/types"
+)
+
+const topCommands = 5
+
+func (it *invocationTrackerImpl) History(ctx context.Context, limit int) ([]types.HistoryEntry, error) {
+	entries := it.history.list()
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (it *invocationTrackerImpl) Stats(ctx context.Context, since int64) (*types.Stats, error) {
+	running, err := it.ListInvocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &types.Stats{
+		Since:   since,
+		Running: len(running),
+	}
+	byCommand := make(map[string]*types.CommandStats)
+	for _, entry := range it.history.list() {
+		if entry.FinishedAt < since {
+			continue
+		}
+		if entry.Abandoned {
+			stats.Abandoned++
+			continue
+		}
+		stats.Finished++
+		if entry.ExitCode != nil && *entry.ExitCode != 0 {
+			stats.Failed++
+		}
+		stats.TotalExecTime += entry.ExecTime
+		stats.MaxExecTime = max(stats.MaxExecTime, entry.ExecTime)
+
+		command := commandName(entry.ShellLine)
+		cs, ok := byCommand[command]
+		if !ok {
+			cs = &types.CommandStats{Command: command}
+			byCommand[command] = cs
+		}
+		cs.Count++
+		cs.TotalExecTime += entry.ExecTime
+	}
+
+	for _, cs := range byCommand {
+		stats.Top = append(stats.Top, *cs)
+	}
+	slices.SortFunc(stats.Top, func(lhs, rhs types.CommandStats) int {
+		return cmp.Or(cmp.Compare(rhs.TotalExecTime, lhs.TotalExecTime), cmp.Compare(lhs.Command, rhs.Command))
+	})
+	if len(stats.Top) > topCommands {
+		stats.Top = stats.Top[:topCommands]
+	}
+	return stats, nil
+}
+
+// commandName returns the binary of the shell line skipping the variable assignments
+func commandName(shellLine string) string {
+	for _, word := range strings.Fields(shellLine) {
+		if strings.Contains(word, "=") {
+			continue
+		}
+		return path.Base(word)
+	}
+	return shellLine
+}
+
+func (it *invocationTrackerImpl) Mute(ctx context.Context, until int64) error {
+	it.mutedUntil.Store(until)
+	if until == 0 {
+		fmt.Printf("notifications are unmuted\n")
+	} else {
+		fmt.Printf("notifications are muted until %d\n", until)
+	}
+	return nil
+}
+
+func (it *invocationTrackerImpl) muted() bool {
+	return it.mutedUntil.Load() > it.clock.NowUnix()
+}
+
+// Kill terminates the command started with 'shnotify run', the wrapper reports its result as usual
+func (it *invocationTrackerImpl) Kill(ctx context.Context, id types.InvocationID) (*types.ShellInvocationRecord, error) {
+	rec, err := it.storage.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !rec.Pending():
+		return nil, fmt.Errorf("invocation %s is not running", id)
+	case rec.ChildPID == 0:
+		return nil, fmt.Errorf("invocation %s is not started with 'shnotify run', only such commands can be killed", id)
+	case rec.MachineID != it.machineID:
+		return nil, fmt.Errorf("invocation %s runs on the other machine %s", id, rec.MachineID)
+	}
+
+	// the pid may be reused after the command is finished, it must be still the child of the wrapper
+	if ppid, err := parentPID(rec.ChildPID); err != nil || ppid != rec.ParentID {
+		return nil, fmt.Errorf("command of invocation %s is not running", id)
+	}
+	if err := syscall.Kill(rec.ChildPID, syscall.SIGTERM); err != nil {
+		return nil, fmt.Errorf("failed to kill command of invocation %s: %w", id, err)
+	}
+	fmt.Printf("command of invocation %s (pid %d) is terminated\n", id, rec.ChildPID)
+	return rec, nil
+}
+
+// parentPID reads the parent of the local process from procfs
+func parentPID(pid int) (int, error) {
+	if !common.ProcessAlive(pid) {
+		return 0, fmt.Errorf("process %d does not exist", pid)
+	}
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// command name in the second field may contain spaces and parentheses
+	stat := string(raw)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	return strconv.Atoi(fields[1])
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+const defaultHistorySize = 1000
+
+// history keeps the last finished invocations in the append-only file, it is rewritten when grows over the limit
+type history struct {
+	mu       sync.Mutex
+	filePath string
+	limit    int
+	entries  []types.HistoryEntry
+}
+
+func newHistory(filePath string, limit int) (*history, error) {
+	if limit <= 0 {
+		limit = defaultHistorySize
+	}
+	h := &history{
+		filePath: filePath,
+		limit:    limit,
+	}
+
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry types.HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // torn write of the last line
+		}
+		h.entries = append(h.entries, entry)
+	}
+	if len(h.entries) > limit {
+		h.entries = h.entries[len(h.entries)-limit:]
+	}
+	return h, scanner.Err()
+}
+
+func (h *history) add(entry types.HistoryEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, entry)
+	// some slack to not rewrite the file on every invocation
+	if len(h.entries) > h.limit+h.limit/4 {
+		h.entries = slices.Clone(h.entries[len(h.entries)-h.limit:])
+		return h.rewriteLocked()
+	}
+
+	marshaled, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(marshaled, '\n'))
+	return err
+}
+
+func (h *history) rewriteLocked() error {
+	tmp, err := os.CreateTemp(path.Dir(h.filePath), ".history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for i := range h.entries {
+		if err := encoder.Encode(&h.entries[i]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.filePath)
+}
+
+// list returns the entries from the latest one
+func (h *history) list() []types.HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ret := slices.Clone(h.entries)
+	slices.Reverse(ret)
+	return ret
+}
+
+func newHistoryEntry(rec *types.ShellInvocationRecord, now, execTimeMs int64, exitCode *int) types.HistoryEntry {
+	entry := types.HistoryEntry{
+		InvocationID: rec.InvocationID,
+		MachineID:    rec.MachineID,
+		ShellLine:    rec.ShellLine,
+		StartedAt:    rec.Timestamp,
+		FinishedAt:   now,
+		ExecTime:     execTimeMs / 1000,
+		ExecTimeMs:   execTimeMs,
+		ExitCode:     exitCode,
+		Abandoned:    rec.AbandonedAt != 0,
+	}
+	if rec.Context != nil {
+		entry.Cwd = rec.Context.Cwd
+	}
+	return entry
+}
@@ -1,19 +1,24 @@
 package core
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/oclaw/shnotify/common"
 	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/events"
 	"github.com/oclaw/shnotify/notify"
-	"github.com/oclaw/shnotify/notify/cli"
-	"github.com/oclaw/shnotify/notify/telegram"
+	"github.com/oclaw/shnotify/outbox"
 	"github.com/oclaw/shnotify/types"
 )
 
@@ -24,17 +29,33 @@ type invocationTrackerImpl struct {
 	clock   common.Clock
 	storage InvocationStorage
 	gen     types.InvocationIDGen
+	events  *events.Bus
 
-	regInitOnce sync.Once
-	registry    *notify.Registry
+	machineID string
+	progress  *progressTimers
+	outbox    *outbox.Outbox
+
+	initOnce sync.Once
+	notifSet atomic.Pointer[notifierSet] // replaced on config reload
+	projects *projectSets
+
+	history    *history
+	mutedUntil atomic.Int64
+	health     *deliveryHealth
 }
 
-var _ InvocationTracker = (*invocationTrackerImpl)(nil)
+var (
+	_ InvocationTracker    = (*invocationTrackerImpl)(nil)
+	_ OutboxManager        = (*invocationTrackerImpl)(nil)
+	_ InvocationController = (*invocationTrackerImpl)(nil)
+	_ StatusReporter       = (*invocationTrackerImpl)(nil)
+)
 
 func NewInvocationTracker(
 	cfg *config.ShellTrackerConfig,
 	clock common.Clock,
 	gen types.InvocationIDGen,
+	bus *events.Bus,
 ) (*invocationTrackerImpl, error) {
 
 	storage, err := NewFsInvocationStorage(cfg.DirPath)
@@ -42,60 +63,91 @@ func NewInvocationTracker(
 		return nil, err
 	}
 
+	machineID, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get machine id: %w", err)
+	}
+
 	it := &invocationTrackerImpl{
-		config:  cfg,
-		storage: storage,
-		gen:     gen,
-		clock:   clock,
+		config:    cfg,
+		storage:   storage,
+		gen:       gen,
+		clock:     clock,
+		events:    bus,
+		machineID: machineID,
+		progress:  newProgressTimers(),
+		projects:  newProjectSets(),
+		health:    newDeliveryHealth(),
+	}
+
+	it.history, err = newHistory(path.Join(cfg.DirPath, "history.jsonl"), cfg.HistorySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	if cfg.AsyncNotifications {
+		outboxCfg := cfg.Outbox
+		if len(outboxCfg.DirPath) == 0 {
+			outboxCfg.DirPath = path.Join(cfg.DirPath, "outbox")
+		}
+		it.outbox, err = outbox.New(outboxCfg, clock, it.deliver)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	switch cfg.InitMode {
 	case config.NotifierInitOnStartup:
-		err = it.initNotifiers()
+		_, err = it.notifiers()
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	if err := it.restoreProgress(context.Background()); err != nil {
+		return nil, err
+	}
+
 	return it, nil
 }
 
-func (it *invocationTrackerImpl) initNotifiers() error {
-
-	doInitNotifiers := func() (*notify.Registry, error) {
-		reg := notify.NewRegistry()
-
-		for _, notif := range it.config.Notifications {
-
-			// TODO abstract to initializer map
-			switch notif.Type {
-			case types.NotificationCLI:
-				reg.RegisterNotifier(types.NotificationCLI, cli.NewCliNotifier(os.Stdout))
-			case types.NotificationTelegram:
-				dir, err := os.UserConfigDir()
-				if err != nil {
-					return nil, err
-				}
-				token, err := os.ReadFile(path.Join(dir, "shnotify", ".tg.token"))
-				if err != nil {
-					return nil, err
-				}
-				notifier, err := telegram.NewTelegramNotifier(string(token), it.config.NotifierSettings.TelegramChatID) // TODO validate presence of chat id
-				if err != nil {
-					return nil, err
-				}
-				reg.RegisterNotifier(types.NotificationTelegram, notifier)
+// Run executes background tasks of the tracker until the context is cancelled
+func (it *invocationTrackerImpl) Run(ctx context.Context) error {
+	if it.outbox != nil {
+		go func() {
+			if err := common.IgnoreErr(it.outbox.Run(ctx), context.Canceled); err != nil {
+				fmt.Printf("outbox finalized with error %v\n", err)
 			}
-		}
+		}()
+	}
 
-		return reg, nil
+	if !it.config.BackgroundTasks {
+		<-ctx.Done()
+		return ctx.Err()
 	}
+	return it.watchOrphans(ctx)
+}
 
-	var err error
-	it.regInitOnce.Do(func() {
-		it.registry, err = doInitNotifiers()
-	})
-	return err
+// Shutdown sends what the tracker holds in memory and the outbox before the exit, the context limits it.
+// Run is expected to be stopped, in-progress timers are restored from the storage at the next start.
+func (it *invocationTrackerImpl) Shutdown(ctx context.Context) {
+	it.progress.mu.Lock()
+	it.progress.stopAllLocked()
+	it.progress.mu.Unlock()
+
+	// batched digests are lost otherwise, they are put into the outbox
+	if set := it.notifSet.Load(); set != nil {
+		flushGates(ctx, set, nil)
+	}
+	for _, set := range it.projects.reset() {
+		flushGates(ctx, set, nil)
+	}
+
+	if it.outbox == nil {
+		return
+	}
+	delivered, left := it.outbox.Drain(ctx)
+	fmt.Printf("outbox drained: %d notifications delivered, %d left pending until the next start\n", delivered, left)
 }
 
 type preprocessedCommand struct {
@@ -119,8 +171,10 @@ func (it *invocationTrackerImpl) SaveInvocation(
 		InvocationID: req.InvocationID,
 		ParentID:     req.ParentID,
 		MachineID:    req.MachineID,
-		Timestamp:    it.clock.NowUnix(),
+		Context:      req.Context,
+		ChildPID:     req.ChildPID,
 	}
+	rec.Timestamp, rec.TimestampMs = common.Stamp(it.clock, req.Timestamp, req.TimestampMs)
 
 	if len(rec.InvocationID) == 0 {
 		var err error
@@ -143,80 +197,300 @@ func (it *invocationTrackerImpl) SaveInvocation(
 		return "", err
 	}
 
+	it.events.Publish(types.Event{
+		Kind:         types.EventInvocationStarted,
+		Timestamp:    rec.Timestamp,
+		InvocationID: rec.InvocationID,
+		MachineID:    rec.MachineID,
+		ParentID:     rec.ParentID,
+		ShellLine:    rec.ShellLine,
+	})
+
+	it.scheduleProgress(&rec)
+
 	return rec.InvocationID, nil
 }
 
-func (it *invocationTrackerImpl) Notify(ctx context.Context, invocationID types.InvocationID) error {
-	var err error
-	switch it.config.InitMode {
-	case config.NotifierInitOnDemand:
-		err = it.initNotifiers()
-	}
+func (it *invocationTrackerImpl) Notify(ctx context.Context, req *types.NotifyRequest) error {
+	set, err := it.notifiers()
 	if err != nil {
 		return err
 	}
 
-	now := it.clock.NowUnix()
+	now, nowMs := common.Stamp(it.clock, req.Timestamp, req.TimestampMs)
 
-	rec, err := it.storage.Get(ctx, invocationID)
+	rec, err := it.storage.Get(ctx, req.InvocationID)
 	if err != nil {
 		return err
 	}
 
-	execTime := now - rec.Timestamp
+	it.cancelProgress(rec.InvocationID)
+	set = it.notifiersFor(ctx, set, rec)
 
-	// TODO abstract config condition matchers
-	for _, notifConfig := range it.config.Notifications {
-		var notificationNeeded bool
-		threshold := notifConfig.Conditions.RunLongerThan
-		if threshold.LessThan(execTime) {
-			notificationNeeded = true
-		}
+	// the time measured by the client does not depend on the wall clock adjustments
+	execTimeMs := req.ExecTimeMs
+	if execTimeMs <= 0 {
+		execTimeMs = max(nowMs-rec.StartedAtMs(), 0)
+	}
+	execTime := execTimeMs / 1000
+
+	it.events.Publish(types.Event{
+		Kind:         types.EventInvocationFinished,
+		Timestamp:    now,
+		InvocationID: rec.InvocationID,
+		MachineID:    rec.MachineID,
+		ParentID:     rec.ParentID,
+		ShellLine:    rec.ShellLine,
+		ExecTime:     execTime,
+		ExecTimeMs:   execTimeMs,
+	})
 
-		if notificationNeeded {
-			notifier, err := it.registry.GetNotifier(ctx, notifConfig.Type)
-			if err != nil {
-				fmt.Printf("notification type '%s' failed: %v\n", notifConfig.Type, err)
-				continue
-			}
-			if err := it.notify(
-				ctx,
-				notifier,
-				&types.NotificationData{
-					Invocation:   rec,
-					NowTimestamp: now,
-					ExecTime:     execTime,
-				}); err != nil {
-				return err
-			}
+	data := &types.NotificationData{
+		Kind:         types.NotificationFinished,
+		Invocation:   rec,
+		NowTimestamp: now,
+		ExecTime:     execTime,
+		ExecTimeMs:   execTimeMs,
+		ExitCode:     req.ExitCode,
+		OutputTail:   req.OutputTail,
+		Focused:      it.resolvePane(ctx, rec, set.config.Notifications...),
+	}
+
+	if err := it.history.add(newHistoryEntry(rec, now, execTimeMs, req.ExitCode)); err != nil {
+		fmt.Printf("failed to save invocation %s into the history: %v\n", rec.InvocationID, err)
+	}
+
+	selectPane := false
+	for _, notifConfig := range set.config.Notifications {
+		if !conditionsMatch(&notifConfig.Conditions, data) && !finalizesLive(&notifConfig, data) {
+			continue
+		}
+		if err := it.dispatch(ctx, set, &notifConfig, data); err != nil {
+			return err
 		}
+		selectPane = selectPane || (notifConfig.Tmux != nil && notifConfig.Tmux.SelectPane)
+	}
+	if selectPane && !data.Focused {
+		it.selectPane(rec)
 	}
 
-	if it.config.CleanupEnabled {
-		err = it.storage.Erase(ctx, invocationID)
+	if set.config.CleanupEnabled {
+		err = it.storage.Erase(ctx, req.InvocationID)
+	} else {
+		rec.FinishedAt = now
+		err = it.storage.Store(ctx, rec)
 	}
 
 	return err
 }
 
+// dispatch sends the notification with the configured notifier instance
+func (it *invocationTrackerImpl) dispatch(
+	ctx context.Context,
+	set *notifierSet,
+	notifConfig *config.Notification,
+	data *types.NotificationData,
+) error {
+	if it.muted() {
+		fmt.Printf("notification '%s' of %s suppressed, notifications are muted\n", notifConfig.ID(), data.Invocation.InvocationID)
+		return nil
+	}
+	if sched, ok := set.schedules[notifConfig.ID()]; ok {
+		now := time.Unix(it.clock.NowUnix(), 0)
+		if !sched.Active(now) {
+			return it.quiet(ctx, set, notifConfig, sched.NextActive(now), data)
+		}
+	}
+	return it.submit(ctx, set, notifConfig.ID(), data)
+}
+
+// quiet handles the notification arrived outside of the active hours of the notifier
+func (it *invocationTrackerImpl) quiet(
+	ctx context.Context,
+	set *notifierSet,
+	notifConfig *config.Notification,
+	activeAt time.Time,
+	data *types.NotificationData,
+) error {
+	name := notifConfig.ID()
+	switch notifConfig.Schedule.QuietAction {
+	case config.QuietDefer:
+		if it.outbox == nil || activeAt.IsZero() {
+			break
+		}
+		// policies of the notifier are applied when it is dispatched at the start of the active hours
+		return it.outbox.Defer(name, data, activeAt.Unix())
+	case config.QuietReroute:
+		// schedule of the target is not checked to never bounce the notification back
+		return it.submit(ctx, set, notifConfig.Schedule.RerouteTo, data)
+	}
+	fmt.Printf("notification '%s' of %s suppressed by quiet hours\n", name, data.Invocation.InvocationID)
+	return nil
+}
+
+// submit passes the notification through the policies of the notifier instance
+func (it *invocationTrackerImpl) submit(ctx context.Context, set *notifierSet, name string, data *types.NotificationData) error {
+	notifier, err := set.registry.GetNotifier(ctx, name)
+	if err != nil {
+		fmt.Printf("notification '%s' failed: %v\n", name, err)
+		return nil
+	}
+	if gate, ok := set.gates[name]; ok {
+		return gate.Submit(ctx, data)
+	}
+	return it.notify(ctx, name, notifier, data)
+}
+
 func (it *invocationTrackerImpl) notify(
 	ctx context.Context,
+	name string,
 	notifier notify.Notifier,
 	data *types.NotificationData,
 ) error {
 
-	call := func(ctx context.Context) error {
-		return notifier.Notify(ctx, data)
+	if it.outbox == nil {
+		return it.send(ctx, name, notifier, data)
 	}
-	if !it.config.AsyncNotifications {
-		return call(ctx)
+	// delivered (and retried if needed) by the outbox workers
+	return it.outbox.Enqueue(name, data)
+}
+
+func (it *invocationTrackerImpl) send(
+	ctx context.Context,
+	name string,
+	notifier notify.Notifier,
+	data *types.NotificationData,
+) error {
+	if err := notifier.Notify(ctx, data); err != nil {
+		it.health.record(name, it.clock.NowUnix(), err)
+		return err
 	}
+	it.health.record(name, it.clock.NowUnix(), nil)
 
-	go func() {
-		ctx := context.WithoutCancel(ctx)
-		if err := call(ctx); err != nil {
-			fmt.Printf("Notification for invocation %s failed: %v", data.Invocation.InvocationID, err)
-		}
-	}()
+	sent := []*types.NotificationData{data}
+	if data.Kind == types.NotificationDigest {
+		sent = data.Digest
+	}
+	for _, item := range sent {
+		it.events.Publish(types.Event{
+			Kind:         types.EventNotificationSent,
+			Timestamp:    it.clock.NowUnix(),
+			InvocationID: item.Invocation.InvocationID,
+			MachineID:    item.Invocation.MachineID,
+			ParentID:     item.Invocation.ParentID,
+			ShellLine:    item.Invocation.ShellLine,
+			ExecTime:     item.ExecTime,
+			ExecTimeMs:   item.ExecTimeMs,
+			Notifier:     name,
+		})
+	}
 	return nil
 }
+
+// deliver sends the notification of the outbox job
+func (it *invocationTrackerImpl) deliver(ctx context.Context, job *types.OutboxJob) error {
+	set, err := it.notifiers()
+	if err != nil {
+		return err
+	}
+	invocation := job.Data.Invocation
+	if job.Data.Kind == types.NotificationDigest && len(job.Data.Digest) != 0 {
+		invocation = job.Data.Digest[0].Invocation
+	}
+	set = it.notifiersFor(ctx, set, invocation)
+
+	if job.Data.Kind == types.NotificationInProgress && !it.isPending(ctx, invocation.InvocationID) {
+		// retried or deferred by the quiet hours until the command has finished, the message is stale
+		fmt.Printf("in-progress notification %s of finished invocation %s is dropped\n", job.ID, invocation.InvocationID)
+		return nil
+	}
+
+	if job.Deferred {
+		// goes through the mute, the schedule and the policies as the notification arrived just now
+		notifConfig := set.notification(job.Notifier)
+		if notifConfig == nil {
+			return fmt.Errorf("notifier '%s' is removed", job.Notifier)
+		}
+		return it.dispatch(ctx, set, notifConfig, job.Data)
+	}
+
+	// notifier may be removed by the config reload, the job ends up in the dead letters then
+	notifier, err := set.registry.GetNotifier(ctx, job.Notifier)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(set.config.DeadlineSec))
+	defer cancel()
+	return it.send(ctx, job.Notifier, notifier, job.Data)
+}
+
+// isPending reports whether the invocation is still running, it is considered so if the storage fails
+func (it *invocationTrackerImpl) isPending(ctx context.Context, id types.InvocationID) bool {
+	rec, err := it.storage.Get(ctx, id)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	return err != nil || rec.Pending()
+}
+
+func (it *invocationTrackerImpl) ListInvocations(ctx context.Context) ([]types.RunningInvocation, error) {
+	records, err := it.storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := it.clock.NowUnix()
+
+	ret := make([]types.RunningInvocation, 0, len(records))
+	for _, rec := range records {
+		if !rec.Pending() {
+			continue
+		}
+		running := types.RunningInvocation{
+			Record:      rec,
+			ElapsedTime: now - rec.Timestamp,
+		}
+		// parent pid is meaningful only on the machine the invocation was started at
+		if rec.MachineID == it.machineID {
+			alive := common.ProcessAlive(rec.ParentID)
+			running.ParentAlive = &alive
+		}
+		ret = append(ret, running)
+	}
+
+	slices.SortFunc(ret, func(lhs, rhs types.RunningInvocation) int {
+		return cmp.Compare(lhs.Record.Timestamp, rhs.Record.Timestamp)
+	})
+
+	return ret, nil
+}
+
+var errOutboxDisabled = errors.New("outbox is disabled (notifications are sent synchronously)")
+
+func (it *invocationTrackerImpl) ListOutbox(ctx context.Context) ([]types.OutboxJob, error) {
+	if it.outbox == nil {
+		return nil, errOutboxDisabled
+	}
+	return it.outbox.List()
+}
+
+func (it *invocationTrackerImpl) RetryOutbox(ctx context.Context, jobID string) (int, error) {
+	if it.outbox == nil {
+		return 0, errOutboxDisabled
+	}
+	if len(jobID) == 0 {
+		return it.outbox.RetryDead()
+	}
+	return 1, it.outbox.Retry(jobID)
+}
+
+func (it *invocationTrackerImpl) DropOutbox(ctx context.Context, jobID string) (int, error) {
+	if it.outbox == nil {
+		return 0, errOutboxDisabled
+	}
+	if len(jobID) == 0 {
+		return it.outbox.DropDead()
+	}
+	return 1, it.outbox.Drop(jobID)
+}
@@ -0,0 +1,353 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oclaw/shnotify/common/clocktest"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/events"
+	"github.com/oclaw/shnotify/notify"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/types"
+	"gopkg.in/yaml.v3"
+)
+
+const testNotifierType types.NotificationType = "test"
+
+// Monday, 2024-01-01 20:00 UTC
+const mondayEvening = 1704139200
+
+// sentLog collects the notifications of the test notifier instances as 'name kind invocation'
+var sentLog struct {
+	mu    sync.Mutex
+	items []string
+}
+
+type testNotifier struct {
+	name string
+}
+
+func (tn *testNotifier) Notify(_ context.Context, data *types.NotificationData) error {
+	sentLog.mu.Lock()
+	defer sentLog.mu.Unlock()
+	sentLog.items = append(sentLog.items, fmt.Sprintf("%s %s %s", tn.name, data.Kind, data.Invocation.InvocationID))
+	return nil
+}
+
+func init() {
+	notifierFactories[testNotifierType] = notifierFactory{
+		defaultFormat: render.FormatPlain,
+		create: func(_ *config.ShellTrackerConfig, notif *config.Notification, _ *render.Template) (notify.Notifier, error) {
+			return &testNotifier{name: notif.ID()}, nil
+		},
+	}
+}
+
+func sent() []string {
+	sentLog.mu.Lock()
+	defer sentLog.mu.Unlock()
+	return slices.Clone(sentLog.items)
+}
+
+// waitSent waits for the notifications sent by the timers of the tracker
+func waitSent(t *testing.T, n int) []string {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if items := sent(); len(items) >= n {
+			return items
+		}
+	}
+	t.Fatalf("%d notifications are sent, expected %d: %v", len(sent()), n, sent())
+	return nil
+}
+
+func testConfig(t *testing.T, raw string) *config.ShellTrackerConfig {
+	t.Helper()
+	var cfg config.ShellTrackerConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	cfg.DeadlineSec = 3
+	cfg.InitMode = config.NotifierInitOnStartup
+	cfg.BackgroundTasks = true
+	return &cfg
+}
+
+// newTestTracker creates the tracker over dirPath (a new one if empty), the notifications are sent synchronously
+// unless async is set, they are put into the outbox then
+func newTestTracker(t *testing.T, clock *clocktest.Clock, dirPath, rawConfig string, async bool) *invocationTrackerImpl {
+	t.Helper()
+	if len(dirPath) == 0 {
+		dirPath = t.TempDir()
+	}
+	cfg := testConfig(t, rawConfig)
+	cfg.DirPath = dirPath
+	cfg.AsyncNotifications = async
+
+	sentLog.mu.Lock()
+	sentLog.items = nil
+	sentLog.mu.Unlock()
+
+	it, err := NewInvocationTracker(cfg, clock, UUIDInvocationGen, events.NewBus())
+	if err != nil {
+		t.Fatalf("failed to create tracker: %v", err)
+	}
+	t.Cleanup(func() {
+		it.progress.mu.Lock()
+		it.progress.stopAllLocked()
+		it.progress.mu.Unlock()
+	})
+	return it
+}
+
+// start saves the invocation started the given time ago
+func start(t *testing.T, it *invocationTrackerImpl, clock *clocktest.Clock, id types.InvocationID, ago time.Duration) {
+	t.Helper()
+	_, err := it.SaveInvocation(context.Background(), &types.InvocationRequest{
+		InvocationID: id,
+		ShellLine:    "make build",
+		MachineID:    it.machineID,
+		ParentID:     os.Getpid(),
+		TimestampMs:  clock.NowUnixMilli() - ago.Milliseconds(),
+	})
+	if err != nil {
+		t.Fatalf("failed to save invocation: %v", err)
+	}
+}
+
+func finish(t *testing.T, it *invocationTrackerImpl, clock *clocktest.Clock, id types.InvocationID) {
+	t.Helper()
+	exitCode := 0
+	err := it.Notify(context.Background(), &types.NotifyRequest{
+		InvocationID: id,
+		TimestampMs:  clock.NowUnixMilli(),
+		ExitCode:     &exitCode,
+	})
+	if err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	const cfg = `
+notifications:
+  - {name: long, type: test, conditions: {run_longer_than: 10s}}
+  - {name: all, type: test, conditions: {run_longer_than: 0s}}
+  - {name: other, type: test, conditions: {run_longer_than: 10s, context: {cwd: /srv/**}}}
+`
+	tests := []struct {
+		name string
+		ran  time.Duration
+		want []string
+	}{
+		{"short command", 5 * time.Second, []string{"all finished a"}},
+		{"long command", 15 * time.Second, []string{"long finished a", "all finished a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.New(mondayEvening)
+			it := newTestTracker(t, clock, "", cfg, false)
+
+			start(t, it, clock, "a", tt.ran)
+			finish(t, it, clock, "a")
+
+			if got := sent(); !slices.Equal(got, tt.want) {
+				t.Errorf("sent %v, expected %v", got, tt.want)
+			}
+			rec, err := it.storage.Get(context.Background(), "a")
+			if err != nil || rec.FinishedAt != mondayEvening {
+				t.Errorf("finished invocation is not kept: %+v, %v", rec, err)
+			}
+			if history, _ := it.History(context.Background(), 10); len(history) != 1 {
+				t.Errorf("history %v, expected the invocation", history)
+			}
+		})
+	}
+}
+
+func TestNotifyUnknown(t *testing.T) {
+	clock := clocktest.New(mondayEvening)
+	it := newTestTracker(t, clock, "", "notifications: [{type: test}]", false)
+	if err := it.Notify(context.Background(), &types.NotifyRequest{InvocationID: "absent"}); err == nil {
+		t.Errorf("notification of the unknown invocation is accepted")
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	const workHours = `{timezone: UTC, active: [{from: "09:00", to: "18:00"}], quiet_action: %s, reroute_to: fallback}`
+	const cfg = `
+notifications:
+  - {name: work, type: test, conditions: {run_longer_than: 0s}, schedule: %s}
+  - {name: fallback, type: test}
+`
+	tuesdayMorning := int64(mondayEvening + 13*60*60)
+
+	tests := []struct {
+		name       string
+		action     config.QuietAction
+		at         int64
+		wantJob    string // notifier of the outbox job, empty if nothing is sent
+		wantDefer  bool
+		wantNextAt int64
+	}{
+		{"active hours", config.QuietSuppress, tuesdayMorning, "work", false, tuesdayMorning},
+		{"suppressed", config.QuietSuppress, mondayEvening, "", false, 0},
+		{"rerouted", config.QuietReroute, mondayEvening, "fallback", false, mondayEvening},
+		{"deferred", config.QuietDefer, mondayEvening, "work", true, tuesdayMorning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.New(tt.at)
+			it := newTestTracker(t, clock, "", fmt.Sprintf(cfg, fmt.Sprintf(workHours, tt.action)), true)
+
+			start(t, it, clock, "a", time.Second)
+			finish(t, it, clock, "a")
+
+			jobs, err := it.outbox.List()
+			if err != nil {
+				t.Fatalf("failed to list outbox: %v", err)
+			}
+			if len(tt.wantJob) == 0 {
+				if len(jobs) != 0 {
+					t.Errorf("jobs %+v, expected none", jobs)
+				}
+				return
+			}
+			if len(jobs) != 1 {
+				t.Fatalf("%d jobs, expected one", len(jobs))
+			}
+			if job := jobs[0]; job.Notifier != tt.wantJob || job.Deferred != tt.wantDefer || job.NextAttemptAt != tt.wantNextAt {
+				t.Errorf("job via %s deferred %v at %d, expected via %s deferred %v at %d",
+					job.Notifier, job.Deferred, job.NextAttemptAt, tt.wantJob, tt.wantDefer, tt.wantNextAt)
+			}
+		})
+	}
+}
+
+func TestDeferredDelivery(t *testing.T) {
+	const cfg = `
+notifications:
+  - name: work
+    type: test
+    conditions: {run_longer_than: 0s}
+    schedule: {timezone: UTC, active: [{from: "09:00", to: "18:00"}], quiet_action: defer}
+`
+	clock := clocktest.New(mondayEvening)
+	it := newTestTracker(t, clock, "", cfg, true)
+	ctx := context.Background()
+
+	start(t, it, clock, "a", time.Second)
+	start(t, it, clock, "b", time.Second)
+	for _, id := range []types.InvocationID{"a", "b"} {
+		rec, _ := it.storage.Get(ctx, id)
+		data := &types.NotificationData{Kind: types.NotificationInProgress, Invocation: rec}
+		if err := it.dispatch(ctx, it.notifSet.Load(), &it.config.Notifications[0], data); err != nil {
+			t.Fatalf("failed to dispatch: %v", err)
+		}
+	}
+	finish(t, it, clock, "b")
+
+	clock.Set(mondayEvening + 13*60*60)
+	jobs, _ := it.outbox.List()
+	for _, job := range jobs {
+		if err := it.deliver(ctx, &job); err != nil {
+			t.Fatalf("failed to deliver: %v", err)
+		}
+	}
+
+	// the in-progress notification of the finished command is stale, the rest are dispatched as the new ones
+	jobs, _ = it.outbox.List()
+	var got []string
+	for _, job := range jobs {
+		if !job.Deferred {
+			got = append(got, fmt.Sprintf("%s %s %s", job.Notifier, job.Data.Kind, job.Data.Invocation.InvocationID))
+		}
+	}
+	slices.Sort(got)
+	if want := []string{"work finished b", "work in_progress a"}; !slices.Equal(got, want) {
+		t.Errorf("dispatched %v, expected %v", got, want)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	const before = `
+notifications:
+  - {name: work, type: test, conditions: {run_longer_than: 1h}}
+  - {name: kept, type: test, conditions: {run_longer_than: 1h}}
+`
+	const after = `
+notifications:
+  - {name: work, type: test, conditions: {run_longer_than: 1s}}
+  - {name: kept, type: test, conditions: {run_longer_than: 1h}}
+`
+	clock := clocktest.New(mondayEvening)
+	it := newTestTracker(t, clock, "", before, false)
+	ctx := context.Background()
+
+	kept, _ := it.notifSet.Load().registry.GetNotifier(ctx, "kept")
+	start(t, it, clock, "a", 10*time.Second)
+
+	if err := it.ApplyConfig(ctx, testConfig(t, "notifications: [{type: test, schedule: {quiet_action: reroute, reroute_to: absent}}]")); err == nil {
+		t.Fatalf("config with unknown reroute target is applied")
+	}
+	if err := it.ApplyConfig(ctx, testConfig(t, after)); err != nil {
+		t.Fatalf("failed to apply config: %v", err)
+	}
+	if notifier, _ := it.notifSet.Load().registry.GetNotifier(ctx, "kept"); notifier != kept {
+		t.Errorf("unchanged notifier is recreated on reload")
+	}
+
+	// the invocation started before the reload is notified with the new conditions
+	finish(t, it, clock, "a")
+	if got, want := sent(), []string{"work finished a"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, expected %v", got, want)
+	}
+}
+
+func TestAbandon(t *testing.T) {
+	const cfg = `
+notifications:
+  - {name: died, type: test, conditions: {on_shell_died: true}}
+  - {name: long, type: test, conditions: {on_shell_died: true, run_longer_than: 1h}}
+`
+	clock := clocktest.New(mondayEvening)
+	it := newTestTracker(t, clock, "", cfg, false)
+	ctx := context.Background()
+
+	shell := exec.Command("true")
+	if err := shell.Run(); err != nil {
+		t.Fatal(err)
+	}
+	start(t, it, clock, "alive", time.Minute)
+	_, err := it.SaveInvocation(ctx, &types.InvocationRequest{
+		InvocationID: "orphan",
+		ShellLine:    "sleep 100",
+		MachineID:    it.machineID,
+		ParentID:     shell.Process.Pid, // exited already
+		Timestamp:    mondayEvening - 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := it.checkOrphans(ctx); err != nil {
+		t.Fatalf("failed to check orphans: %v", err)
+	}
+	if got, want := sent(), []string{"died abandoned orphan"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, expected %v", got, want)
+	}
+	if rec, err := it.storage.Get(ctx, "orphan"); err != nil || rec.AbandonedAt != mondayEvening {
+		t.Errorf("orphan is not marked as abandoned: %+v, %v", rec, err)
+	}
+	running, err := it.ListInvocations(ctx)
+	if err != nil || len(running) != 1 || running[0].Record.InvocationID != "alive" {
+		t.Errorf("running invocations %+v, %v, expected the alive one", running, err)
+	}
+}
@@ -8,11 +8,44 @@ import (
 
 type InvocationTracker interface {
 	SaveInvocation(ctx context.Context, req *types.InvocationRequest) (types.InvocationID, error)
-	Notify(ctx context.Context, id types.InvocationID) error
+	Notify(ctx context.Context, req *types.NotifyRequest) error
+	ListInvocations(ctx context.Context) ([]types.RunningInvocation, error)
+}
+
+// OutboxManager gives access to the notifications awaiting delivery
+type OutboxManager interface {
+	ListOutbox(ctx context.Context) ([]types.OutboxJob, error)
+	RetryOutbox(ctx context.Context, jobID string) (int, error) // all dead letters if id is empty
+	DropOutbox(ctx context.Context, jobID string) (int, error)  // all dead letters if id is empty
+}
+
+// InvocationController gives the remote control over the tracker (e.g. to the chat bot)
+type InvocationController interface {
+	History(ctx context.Context, limit int) ([]types.HistoryEntry, error) // latest first
+	Stats(ctx context.Context, since int64) (*types.Stats, error)
+	Mute(ctx context.Context, until int64) error // notifications are not sent until the time, zero unmutes
+	Kill(ctx context.Context, id types.InvocationID) (*types.ShellInvocationRecord, error)
+}
+
+// StatusReporter describes the state of the tracker for the daemon status
+type StatusReporter interface {
+	Status(ctx context.Context) (*types.TrackerStatus, error)
+}
+
+// DaemonController exposes the state of the daemon process and stops it on request
+type DaemonController interface {
+	Status(ctx context.Context) (*types.DaemonStatus, error)
+	Stop(ctx context.Context) error
+}
+
+// Reloader applies the updated configuration without the restart of the daemon
+type Reloader interface {
+	Reload(ctx context.Context) error
 }
 
 type InvocationStorage interface {
 	Store(ctx context.Context, rec *types.ShellInvocationRecord) error
 	Get(ctx context.Context, id types.InvocationID) (*types.ShellInvocationRecord, error)
 	Erase(ctx context.Context, id types.InvocationID) error
+	List(ctx context.Context) ([]*types.ShellInvocationRecord, error)
 }
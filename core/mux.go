@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/mux"
+	"github.com/oclaw/shnotify/types"
+)
+
+const (
+	// the pane is resolved while the hook awaits the daemon, hook_budget is 200ms by default
+	paneQueryTimeout  = 50 * time.Millisecond
+	paneSelectTimeout = time.Second
+)
+
+// needsPane reports whether any of the notifications depends on the focus or selects the tmux pane
+func needsPane(notifs ...config.Notification) bool {
+	for _, notif := range notifs {
+		if notif.Conditions.OnlyWhenUnfocused || (notif.Tmux != nil && notif.Tmux.SelectPane) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePane fills the session:window.pane of the invocation and reports whether its pane is focused.
+// tmux is asked only if the notifications need it, the panes of the other machines, the screen windows
+// and the panes tmux has not reported in time are considered unfocused
+func (it *invocationTrackerImpl) resolvePane(ctx context.Context, rec *types.ShellInvocationRecord, notifs ...config.Notification) bool {
+	ic := rec.Context
+	if ic == nil {
+		return false
+	}
+	if len(ic.Screen) != 0 && len(ic.Pane) == 0 {
+		ic.Pane = ic.Screen
+		if len(ic.ScreenWindow) != 0 {
+			ic.Pane += ":" + ic.ScreenWindow
+		}
+	}
+	if len(ic.TmuxPane) == 0 || rec.MachineID != it.machineID || !needsPane(notifs...) {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, paneQueryTimeout)
+	defer cancel()
+
+	pane, err := mux.TmuxPane(ctx, ic.TmuxSocket, ic.TmuxPane)
+	if err != nil {
+		// the pane or the whole server may be gone already
+		fmt.Printf("failed to check tmux pane of invocation %s: %v\n", rec.InvocationID, err)
+		return false
+	}
+	ic.Pane = pane.Target
+	return pane.Focused
+}
+
+// selectPane brings the tmux pane of the invocation resolved by resolvePane to front, the hook does not wait for it
+func (it *invocationTrackerImpl) selectPane(rec *types.ShellInvocationRecord) {
+	ic := rec.Context
+	if ic == nil || len(ic.TmuxPane) == 0 || len(ic.Pane) == 0 || rec.MachineID != it.machineID {
+		return
+	}
+
+	socket, paneID, id := ic.TmuxSocket, ic.TmuxPane, rec.InvocationID
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), paneSelectTimeout)
+		defer cancel()
+
+		if err := mux.SelectTmuxPane(ctx, socket, paneID); err != nil {
+			fmt.Printf("failed to select tmux pane of invocation %s: %v\n", id, err)
+		}
+	}()
+}
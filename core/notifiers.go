@@ -0,0 +1,294 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/notify"
+	"github.com/oclaw/shnotify/notify/cli"
+	"github.com/oclaw/shnotify/notify/osc"
+	"github.com/oclaw/shnotify/notify/policy"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/notify/telegram"
+	"github.com/oclaw/shnotify/schedule"
+	"github.com/oclaw/shnotify/types"
+)
+
+type notifierFactory struct {
+	defaultFormat render.Format
+	create        func(cfg *config.ShellTrackerConfig, notif *config.Notification, tmpl *render.Template) (notify.Notifier, error)
+}
+
+var notifierFactories = map[types.NotificationType]notifierFactory{
+	types.NotificationCLI: {
+		defaultFormat: render.FormatPlain,
+		create: func(_ *config.ShellTrackerConfig, _ *config.Notification, tmpl *render.Template) (notify.Notifier, error) {
+			return cli.NewCliNotifier(os.Stdout, tmpl), nil
+		},
+	},
+	types.NotificationOSC: {
+		defaultFormat: render.FormatPlain,
+		create: func(_ *config.ShellTrackerConfig, notif *config.Notification, tmpl *render.Template) (notify.Notifier, error) {
+			if len(notif.Template) == 0 && len(notif.TemplateFile) == 0 {
+				var err error
+				if tmpl, err = render.New(notif.ID(), osc.DefaultTemplate, render.FormatPlain); err != nil {
+					return nil, err
+				}
+			}
+			machineID, err := os.Hostname()
+			if err != nil {
+				return nil, err
+			}
+			var options config.OSCOptions
+			if notif.OSC != nil {
+				options = *notif.OSC
+			}
+			return osc.NewOSCNotifier(options, machineID, tmpl), nil
+		},
+	},
+	types.NotificationTelegram: {
+		defaultFormat: render.FormatMarkdownV2,
+		create: func(cfg *config.ShellTrackerConfig, notif *config.Notification, tmpl *render.Template) (notify.Notifier, error) {
+			token, err := telegram.ResolveToken(context.Background(), &cfg.NotifierSettings)
+			if err != nil {
+				return nil, err
+			}
+			var options config.TelegramOptions
+			if notif.Telegram != nil {
+				options = *notif.Telegram
+			}
+			return telegram.NewTelegramNotifier(
+				token,
+				cfg.NotifierSettings.TelegramAPIURL,
+				cfg.NotifierSettings.TelegramChatID,
+				options,
+				path.Join(cfg.DirPath, "telegram", notif.ID()),
+				tmpl,
+			)
+		},
+	},
+}
+
+// SupportedNotificationTypes returns the types of the notifiers the tracker is able to create
+func SupportedNotificationTypes() []types.NotificationType {
+	ret := slices.Collect(maps.Keys(notifierFactories))
+	slices.Sort(ret)
+	return ret
+}
+
+// notifierSet is the part of the tracker built from the config and replaced as a whole on reload
+type notifierSet struct {
+	base      *notifierSet // set of the machine config the project set is derived from, nil for the root set
+	config    *config.ShellTrackerConfig
+	registry  *notify.Registry
+	gates     map[string]*policy.Gate       // policies of the notifier instances
+	schedules map[string]*schedule.Schedule // active hours of the notifier instances
+}
+
+// root returns the set built from the machine config, it is the one replaced on reload
+func (set *notifierSet) root() *notifierSet {
+	if set.base != nil {
+		return set.base
+	}
+	return set
+}
+
+// notification returns the config of the notifier instance, nil if it is absent
+func (set *notifierSet) notification(name string) *config.Notification {
+	for i := range set.config.Notifications {
+		if set.config.Notifications[i].ID() == name {
+			return &set.config.Notifications[i]
+		}
+	}
+	return nil
+}
+
+var errNotifiersNotReady = errors.New("notifiers are not initialized")
+
+// notifiers returns the current notifier set creating it from the startup config at the first call
+func (it *invocationTrackerImpl) notifiers() (*notifierSet, error) {
+	var err error
+	it.initOnce.Do(func() {
+		var set *notifierSet
+		if set, err = it.buildNotifiers(it.config, nil); err == nil {
+			it.notifSet.Store(set)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	set := it.notifSet.Load()
+	if set == nil {
+		return nil, errNotifiersNotReady
+	}
+	return set, nil
+}
+
+// buildNotifiers creates the notifier set from the config. The instances of the shared set (the running one
+// on reload, the machine one for the projects) with unchanged configuration are reused so they keep their policy state.
+func (it *invocationTrackerImpl) buildNotifiers(cfg *config.ShellTrackerConfig, shared *notifierSet) (*notifierSet, error) {
+	set := &notifierSet{
+		config:    cfg,
+		registry:  notify.NewRegistry(),
+		gates:     make(map[string]*policy.Gate),
+		schedules: make(map[string]*schedule.Schedule),
+	}
+
+	for i := range cfg.Notifications {
+		notif := &cfg.Notifications[i]
+
+		factory, ok := notifierFactories[notif.Type]
+		if !ok {
+			continue // reported as unsupported when used
+		}
+		if set.registry.Has(notif.ID()) {
+			return nil, fmt.Errorf("duplicate notifier name '%s'", notif.ID())
+		}
+
+		if shared != nil && shared.shares(cfg, notif) {
+			name := notif.ID()
+			notifier, _ := shared.registry.GetNotifier(context.Background(), name)
+			set.registry.RegisterNotifier(name, notifier)
+			if gate, ok := shared.gates[name]; ok {
+				set.gates[name] = gate
+			}
+			if sched, ok := shared.schedules[name]; ok {
+				set.schedules[name] = sched
+			}
+			continue
+		}
+
+		tmpl, err := newNotifierTemplate(notif, factory.defaultFormat)
+		if err != nil {
+			return nil, err
+		}
+		notifier, err := factory.create(cfg, notif, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		set.registry.RegisterNotifier(notif.ID(), notifier)
+
+		if !notif.Policy.Empty() {
+			name := notif.ID()
+			set.gates[name] = policy.NewGate(
+				name,
+				notif.Policy,
+				it.clock,
+				func(ctx context.Context, data *types.NotificationData) error {
+					return it.notify(ctx, name, notifier, data)
+				},
+				time.Second*time.Duration(cfg.DeadlineSec),
+			)
+		}
+
+		if notif.Schedule != nil {
+			sched, err := newNotifierSchedule(notif)
+			if err != nil {
+				return nil, err
+			}
+			set.schedules[notif.ID()] = sched
+		}
+	}
+
+	for _, notif := range cfg.Notifications {
+		if notif.Schedule == nil || notif.Schedule.QuietAction != config.QuietReroute {
+			continue
+		}
+		if !set.registry.Has(notif.Schedule.RerouteTo) {
+			return nil, fmt.Errorf("notifier '%s': unknown reroute target '%s'", notif.ID(), notif.Schedule.RerouteTo)
+		}
+	}
+
+	return set, nil
+}
+
+// shares reports whether the notifier instance of the set can be used for the notification entry of the config
+func (set *notifierSet) shares(cfg *config.ShellTrackerConfig, notif *config.Notification) bool {
+	prev := set.notification(notif.ID())
+	return prev != nil &&
+		set.registry.Has(notif.ID()) &&
+		reflect.DeepEqual(prev, notif) &&
+		reflect.DeepEqual(set.config.NotifierSettings, cfg.NotifierSettings)
+}
+
+// ApplyConfig replaces notifiers, conditions and policies with the ones of the new config.
+// Nothing is changed if the notifiers cannot be created from it. Pending invocations are kept,
+// in-progress timers are rebuilt for the new conditions and batched digests of the old policies are flushed.
+// The notifiers with unchanged configuration keep their instances and policy state.
+func (it *invocationTrackerImpl) ApplyConfig(ctx context.Context, cfg *config.ShellTrackerConfig) error {
+	current, err := it.notifiers()
+	if err != nil {
+		return err
+	}
+
+	set, err := it.buildNotifiers(cfg, current)
+	if err != nil {
+		return err
+	}
+
+	it.progress.mu.Lock()
+	prev := it.notifSet.Swap(set)
+	it.progress.stopAllLocked()
+	it.progress.mu.Unlock()
+	derived := it.projects.reset()
+
+	if err := it.restoreProgress(ctx); err != nil {
+		fmt.Printf("failed to restore in-progress timers after reload: %v\n", err)
+	}
+
+	flushGates(ctx, prev, set)
+	for _, projectSet := range derived {
+		flushGates(ctx, projectSet, set)
+	}
+	return nil
+}
+
+// flushGates sends the batched digests of the set, the gates taken over by the kept set (if any) go on batching
+func flushGates(ctx context.Context, set, kept *notifierSet) {
+	for name, gate := range set.gates {
+		if kept != nil && kept.gates[name] == gate {
+			continue
+		}
+		if err := gate.Flush(ctx); err != nil {
+			fmt.Printf("failed to flush digest of notifier %s: %v\n", name, err)
+		}
+	}
+}
+
+func newNotifierTemplate(notif *config.Notification, defaultFormat render.Format) (*render.Template, error) {
+	format := defaultFormat
+	if len(notif.Format) != 0 {
+		var err error
+		if format, err = render.ParseFormat(notif.Format); err != nil {
+			return nil, fmt.Errorf("notifier '%s': %w", notif.ID(), err)
+		}
+	}
+
+	if len(notif.Template) == 0 && len(notif.TemplateFile) != 0 {
+		return render.NewFromFile(notif.ID(), notif.TemplateFile, format)
+	}
+	return render.New(notif.ID(), notif.Template, format)
+}
+
+func newNotifierSchedule(notif *config.Notification) (*schedule.Schedule, error) {
+	switch notif.Schedule.QuietAction {
+	case "", config.QuietSuppress, config.QuietDefer, config.QuietReroute:
+	default:
+		return nil, fmt.Errorf("notifier '%s': unknown quiet action '%s'", notif.ID(), notif.Schedule.QuietAction)
+	}
+
+	sched, err := schedule.New(notif.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("notifier '%s': %w", notif.ID(), err)
+	}
+	return sched, nil
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/types"
+)
+
+const defaultOrphanCheckInterval = 10 * time.Second
+
+// watchOrphans periodically checks the shells of the pending invocations until the context is cancelled
+func (it *invocationTrackerImpl) watchOrphans(ctx context.Context) error {
+	interval := time.Duration(it.config.OrphanCheckInterval)
+	if interval <= 0 {
+		interval = defaultOrphanCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := it.checkOrphans(ctx); err != nil {
+				fmt.Printf("orphaned invocations check failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// checkOrphans marks pending invocations whose parent shell has gone as abandoned.
+// notify will never be called for them since there is no shell to run precmd hook.
+func (it *invocationTrackerImpl) checkOrphans(ctx context.Context) error {
+	records, err := it.storage.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if !rec.Pending() || rec.MachineID != it.machineID || common.ProcessAlive(rec.ParentID) {
+			continue
+		}
+		if err := it.abandon(ctx, rec); err != nil {
+			fmt.Printf("failed to abandon invocation %s: %v\n", rec.InvocationID, err)
+		}
+	}
+	return nil
+}
+
+func (it *invocationTrackerImpl) abandon(ctx context.Context, rec *types.ShellInvocationRecord) error {
+	set, err := it.notifiers()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(set.config.DeadlineSec))
+	defer cancel()
+
+	it.cancelProgress(rec.InvocationID)
+	set = it.notifiersFor(ctx, set, rec)
+
+	nowMs := it.clock.NowUnixMilli()
+	now := nowMs / 1000
+	execTimeMs := max(nowMs-rec.StartedAtMs(), 0)
+	execTime := execTimeMs / 1000
+
+	rec.AbandonedAt = now
+	if err := it.storage.Store(ctx, rec); err != nil {
+		return err
+	}
+
+	it.events.Publish(types.Event{
+		Kind:         types.EventInvocationAbandoned,
+		Timestamp:    now,
+		InvocationID: rec.InvocationID,
+		MachineID:    rec.MachineID,
+		ParentID:     rec.ParentID,
+		ShellLine:    rec.ShellLine,
+		ExecTime:     execTime,
+		ExecTimeMs:   execTimeMs,
+	})
+
+	if err := it.history.add(newHistoryEntry(rec, now, execTimeMs, nil)); err != nil {
+		fmt.Printf("failed to save invocation %s into the history: %v\n", rec.InvocationID, err)
+	}
+
+	data := &types.NotificationData{
+		Kind:         types.NotificationAbandoned,
+		Invocation:   rec,
+		NowTimestamp: now,
+		ExecTime:     execTime,
+		ExecTimeMs:   execTimeMs,
+	}
+
+	for _, notifConfig := range set.config.Notifications {
+		if !conditionsMatch(&notifConfig.Conditions, data) && !finalizesLive(&notifConfig, data) {
+			continue
+		}
+		if err := it.dispatch(ctx, set, &notifConfig, data); err != nil {
+			fmt.Printf("abandoned notification for invocation %s failed: %v\n", rec.InvocationID, err)
+		}
+	}
+
+	if set.config.CleanupEnabled {
+		return it.storage.Erase(ctx, rec.InvocationID)
+	}
+	return nil
+}
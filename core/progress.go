@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+// progressTimers holds the timers of in-progress notifications per invocation and notification entry
+type progressTimers struct {
+	mu     sync.Mutex
+	timers map[types.InvocationID]map[int]*time.Timer
+}
+
+func newProgressTimers() *progressTimers {
+	return &progressTimers{
+		timers: make(map[types.InvocationID]map[int]*time.Timer),
+	}
+}
+
+// scheduleProgress starts timers for every notification entry with in-progress conditions
+func (it *invocationTrackerImpl) scheduleProgress(rec *types.ShellInvocationRecord) {
+	if !it.config.BackgroundTasks {
+		return
+	}
+
+	base := it.notifSet.Load()
+	if base == nil {
+		return
+	}
+	// project config is read outside of the lock, the set is checked to be current under it
+	set := it.notifiersFor(context.Background(), base, rec)
+
+	// the reload swaps the notifier set and rebuilds the timers under the same lock
+	it.progress.mu.Lock()
+	defer it.progress.mu.Unlock()
+
+	if it.notifSet.Load() != base {
+		return // timers are rebuilt by the reload
+	}
+	for idx := range set.config.Notifications {
+		it.scheduleProgressLocked(set, rec, idx)
+	}
+}
+
+func (it *invocationTrackerImpl) scheduleProgressLocked(set *notifierSet, rec *types.ShellInvocationRecord, idx int) {
+	due, ok := nextProgressDue(&set.config.Notifications[idx], rec)
+	if !ok {
+		return
+	}
+
+	id := rec.InvocationID
+	delay := time.Duration(due-it.clock.NowUnix()) * time.Second
+	timer := time.AfterFunc(max(delay, 0), func() {
+		it.fireProgress(set, id, idx)
+	})
+
+	entries, ok := it.progress.timers[id]
+	if !ok {
+		entries = make(map[int]*time.Timer)
+		it.progress.timers[id] = entries
+	}
+	if prev, ok := entries[idx]; ok {
+		prev.Stop()
+	}
+	entries[idx] = timer
+}
+
+// stopAllLocked cancels all the timers, they are rebuilt for the new notifier set on reload
+func (pt *progressTimers) stopAllLocked() {
+	for id, entries := range pt.timers {
+		for _, timer := range entries {
+			timer.Stop()
+		}
+		delete(pt.timers, id)
+	}
+}
+
+// cancelProgress stops the timers of the invocation, pending in-progress notifications are not sent after that
+func (it *invocationTrackerImpl) cancelProgress(id types.InvocationID) {
+	it.progress.mu.Lock()
+	defer it.progress.mu.Unlock()
+
+	for _, timer := range it.progress.timers[id] {
+		timer.Stop()
+	}
+	delete(it.progress.timers, id)
+}
+
+func (it *invocationTrackerImpl) isProgressScheduled(id types.InvocationID) bool {
+	it.progress.mu.Lock()
+	defer it.progress.mu.Unlock()
+	_, ok := it.progress.timers[id]
+	return ok
+}
+
+func (it *invocationTrackerImpl) fireProgress(set *notifierSet, id types.InvocationID, idx int) {
+	if !it.isProgressScheduled(id) || it.notifSet.Load() != set.root() {
+		// finished or the timer belongs to the config replaced by reload
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(set.config.DeadlineSec))
+	defer cancel()
+
+	rec, err := it.storage.Get(ctx, id)
+	if err != nil {
+		fmt.Printf("failed to get invocation %s for in-progress notification: %v\n", id, err)
+		return
+	}
+
+	nowMs := it.clock.NowUnixMilli()
+	now := nowMs / 1000
+	execTimeMs := max(nowMs-rec.StartedAtMs(), 0)
+	notifConfig := set.config.Notifications[idx]
+	data := &types.NotificationData{
+		Kind:         types.NotificationInProgress,
+		Invocation:   rec,
+		NowTimestamp: now,
+		ExecTime:     execTimeMs / 1000,
+		ExecTimeMs:   execTimeMs,
+		Focused:      it.resolvePane(ctx, rec, notifConfig),
+	}
+	if conditionsMatch(&notifConfig.Conditions, data) {
+		if err := it.dispatch(ctx, set, &notifConfig, data); err != nil {
+			fmt.Printf("in-progress notification for invocation %s failed: %v\n", id, err)
+		}
+	}
+
+	it.progress.mu.Lock()
+	defer it.progress.mu.Unlock()
+
+	// invocation may be finished or config reloaded while the notification was being sent
+	if _, ok := it.progress.timers[id]; !ok || it.notifSet.Load() != set.root() {
+		return
+	}
+
+	// the other entries of the invocation may have stored their time meanwhile
+	if fresh, err := it.storage.Get(ctx, id); err == nil {
+		rec = fresh
+	}
+	if rec.ProgressNotified == nil {
+		rec.ProgressNotified = make(map[string]int64)
+	}
+	rec.ProgressNotified[notifConfig.ID()] = now
+	if err := it.storage.Store(ctx, rec); err != nil {
+		fmt.Printf("failed to save in-progress state of invocation %s: %v\n", id, err)
+	}
+	delete(it.progress.timers[id], idx)
+	it.scheduleProgressLocked(set, rec, idx)
+}
+
+// restoreProgress rebuilds the timers of pending invocations after the restart
+func (it *invocationTrackerImpl) restoreProgress(ctx context.Context) error {
+	if !it.config.BackgroundTasks {
+		return nil
+	}
+
+	records, err := it.storage.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if !rec.Pending() {
+			continue
+		}
+		it.scheduleProgress(rec)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/oclaw/shnotify/common/clocktest"
+)
+
+func TestProgress(t *testing.T) {
+	const cfg = `
+notifications:
+  - {name: soon, type: test, conditions: {still_running_after: 10s, every: 1h}}
+  - {name: later, type: test, conditions: {still_running_after: 1h}}
+  - {name: final, type: test, conditions: {run_longer_than: 1h}}
+`
+	clock := clocktest.New(mondayEvening)
+	it := newTestTracker(t, clock, "", cfg, false)
+	ctx := context.Background()
+
+	// the due time has passed already, the timer fires at once
+	start(t, it, clock, "a", 20*time.Second)
+	if got, want := waitSent(t, 1), []string{"soon in_progress a"}; !slices.Equal(got, want) {
+		t.Fatalf("sent %v, expected %v", got, want)
+	}
+
+	rec, err := it.storage.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ProgressNotified["soon"] != mondayEvening || rec.ProgressNotified["later"] != 0 {
+		t.Errorf("in-progress times %v, expected the one of 'soon'", rec.ProgressNotified)
+	}
+	if due, _ := nextProgressDue(&it.config.Notifications[0], rec); due != mondayEvening-20+10+3600 {
+		t.Errorf("next in-progress notification at %d, expected in an hour after the first one", due)
+	}
+
+	finish(t, it, clock, "a")
+	if it.isProgressScheduled("a") {
+		t.Errorf("in-progress timers are left after the invocation has finished")
+	}
+	if got := sent(); len(got) != 1 {
+		t.Errorf("sent %v after the finish, expected nothing more", got)
+	}
+}
+
+func TestProgressRestored(t *testing.T) {
+	const cfg = `
+notifications:
+  - {name: later, type: test, conditions: {still_running_after: 1h}}
+`
+	clock := clocktest.New(mondayEvening)
+	dirPath := t.TempDir()
+	stopped := newTestTracker(t, clock, dirPath, cfg, false)
+	start(t, stopped, clock, "a", time.Minute)
+	if !stopped.isProgressScheduled("a") {
+		t.Fatalf("in-progress timer is not scheduled")
+	}
+	stopped.Shutdown(context.Background())
+
+	// the daemon is started again when the notification is due
+	clock.Advance(time.Hour)
+	it := newTestTracker(t, clock, dirPath, cfg, false)
+	if got, want := waitSent(t, 1), []string{"later in_progress a"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, expected %v", got, want)
+	}
+	finish(t, it, clock, "a")
+}
+
+func TestProgressReload(t *testing.T) {
+	clock := clocktest.New(mondayEvening)
+	it := newTestTracker(t, clock, "", "notifications: [{name: final, type: test, conditions: {run_longer_than: 1s}}]", false)
+
+	start(t, it, clock, "a", time.Minute)
+	if it.isProgressScheduled("a") {
+		t.Fatalf("in-progress timer is scheduled without in-progress conditions")
+	}
+
+	// the timers of the pending invocations are rebuilt for the new conditions
+	if err := it.ApplyConfig(context.Background(), testConfig(t, "notifications: [{name: soon, type: test, conditions: {still_running_after: 10s}}]")); err != nil {
+		t.Fatalf("failed to apply config: %v", err)
+	}
+	if got, want := waitSent(t, 1), []string{"soon in_progress a"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/types"
+)
+
+// projectSets caches the notifier sets extended with the project configs found from the invocation directories
+type projectSets struct {
+	mu   sync.Mutex
+	sets map[string]*projectSet // by path of the project config
+}
+
+type projectSet struct {
+	base    *notifierSet
+	modTime time.Time
+	set     *notifierSet // nil if the project config is broken, the base set is used then
+}
+
+func newProjectSets() *projectSets {
+	return &projectSets{
+		sets: make(map[string]*projectSet),
+	}
+}
+
+// reset drops the cached sets returning the ones which were built
+func (ps *projectSets) reset() []*notifierSet {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var ret []*notifierSet
+	for projectPath, entry := range ps.sets {
+		if entry.set != nil {
+			ret = append(ret, entry.set)
+		}
+		delete(ps.sets, projectPath)
+	}
+	return ret
+}
+
+// notifiersFor returns the notifier set for the invocation: the project config found from its working directory
+// extends the set of the machine config. Directories of the other machines are not looked up.
+func (it *invocationTrackerImpl) notifiersFor(ctx context.Context, set *notifierSet, rec *types.ShellInvocationRecord) *notifierSet {
+	if rec == nil || rec.Context == nil || len(rec.Context.Cwd) == 0 || rec.MachineID != it.machineID {
+		return set
+	}
+	projectPath, ok := config.FindProject(rec.Context.Cwd)
+	if !ok {
+		return set
+	}
+	info, err := os.Stat(projectPath)
+	if err != nil {
+		return set
+	}
+
+	it.projects.mu.Lock()
+	defer it.projects.mu.Unlock()
+
+	entry, ok := it.projects.sets[projectPath]
+	if ok && entry.base == set && entry.modTime.Equal(info.ModTime()) {
+		if entry.set == nil {
+			return set
+		}
+		return entry.set
+	}
+	if ok && entry.set != nil {
+		flushGates(ctx, entry.set, set)
+	}
+
+	entry = &projectSet{
+		base:    set,
+		modTime: info.ModTime(),
+	}
+	it.projects.sets[projectPath] = entry
+
+	cfg, err := config.WithProject(set.config, projectPath)
+	if err == nil {
+		entry.set, err = it.buildNotifiers(cfg, set)
+	}
+	if err != nil {
+		fmt.Printf("project config %s is ignored: %v\n", projectPath, err)
+		return set
+	}
+	entry.set.base = set
+	return entry.set
+}
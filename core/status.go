@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"sync"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+// deliveryHealth keeps the outcome of the latest deliveries per notifier instance
+type deliveryHealth struct {
+	mu     sync.Mutex
+	byName map[string]types.NotifierHealth
+}
+
+func newDeliveryHealth() *deliveryHealth {
+	return &deliveryHealth{
+		byName: make(map[string]types.NotifierHealth),
+	}
+}
+
+func (dh *deliveryHealth) record(name string, at int64, err error) {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	health := dh.byName[name]
+	if err != nil {
+		health.LastFailedAt = at
+		health.LastError = err.Error()
+	} else {
+		health.LastSentAt = at
+	}
+	dh.byName[name] = health
+}
+
+func (dh *deliveryHealth) get(name string) types.NotifierHealth {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	return dh.byName[name]
+}
+
+func (it *invocationTrackerImpl) Status(ctx context.Context) (*types.TrackerStatus, error) {
+	set, err := it.notifiers()
+	if err != nil {
+		return nil, err
+	}
+	running, err := it.ListInvocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &types.TrackerStatus{
+		Notifiers:          make([]types.NotifierHealth, 0, len(set.config.Notifications)),
+		PendingInvocations: len(running),
+	}
+	for i := range set.config.Notifications {
+		notif := &set.config.Notifications[i]
+		health := it.health.get(notif.ID())
+		health.Name = notif.ID()
+		health.Type = notif.Type
+		status.Notifiers = append(status.Notifiers, health)
+	}
+
+	if it.outbox != nil {
+		jobs, err := it.outbox.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range jobs {
+			if job.Dead {
+				status.DeadLetters++
+			} else {
+				status.OutboxDepth++
+			}
+		}
+	}
+	return status, nil
+}
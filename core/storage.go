@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/oclaw/shnotify/common"
 	"github.com/oclaw/shnotify/types"
@@ -36,15 +37,13 @@ func (st *fsInvocationStorage) Store(ctx context.Context, rec *types.ShellInvoca
 		return err
 	}
 
+	// write + rename, the record is read concurrently by the notification and the listing
 	filename := fmt.Sprintf("%s.json", rec.InvocationID)
-	file, err := os.OpenFile(path.Join(st.dirPath, filename), os.O_WRONLY|os.O_CREATE, os.ModePerm)
-	if err != nil {
+	tmpName := path.Join(st.dirPath, "."+filename)
+	if err := os.WriteFile(tmpName, marshaled, os.ModePerm); err != nil {
 		return err
 	}
-	defer file.Close()
-
-	_, err = file.Write(marshaled)
-	return err
+	return os.Rename(tmpName, path.Join(st.dirPath, filename))
 }
 
 func (st *fsInvocationStorage) Get(ctx context.Context, id types.InvocationID) (*types.ShellInvocationRecord, error) {
@@ -67,6 +66,29 @@ func (st *fsInvocationStorage) Get(ctx context.Context, id types.InvocationID) (
 }
 
 func (st *fsInvocationStorage) Erase(ctx context.Context, id types.InvocationID) error {
-	err := os.Remove(fmt.Sprintf("%s.json", id))
+	err := os.Remove(path.Join(st.dirPath, fmt.Sprintf("%s.json", id)))
 	return common.IgnoreErr(err, os.ErrNotExist)
 }
+
+func (st *fsInvocationStorage) List(ctx context.Context) ([]*types.ShellInvocationRecord, error) {
+	entries, err := os.ReadDir(st.dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*types.ShellInvocationRecord, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || strings.HasPrefix(id, ".") {
+			continue
+		}
+		rec, err := st.Get(ctx, types.InvocationID(id))
+		if err != nil {
+			// the record may be erased concurrently or be written right now
+			continue
+		}
+		ret = append(ret, rec)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+// Bus fans out tracker events to the subscribers.
+// Publishing never blocks: events are dropped for subscribers that do not keep up.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan types.Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[chan types.Event]struct{}),
+	}
+}
+
+func (b *Bus) Publish(ev types.Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		select {
+		case sub <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the channel of events and the function to release the subscription
+func (b *Bus) Subscribe(buffer int) (<-chan types.Event, func()) {
+	sub := make(chan types.Event, buffer)
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+			close(sub)
+		})
+	}
+}
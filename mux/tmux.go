@@ -0,0 +1,73 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Pane is the state of the terminal multiplexer pane the command was started in
+type Pane struct {
+	Target  string // session:window.pane
+	Focused bool   // active pane of the active window in the session attached to a client
+}
+
+const paneFormat = "#{pane_id}\t#{session_name}:#{window_index}.#{pane_index}\t#{pane_active}\t#{window_active}\t#{session_attached}"
+
+// TmuxSocket extracts the server socket from $TMUX ('/tmp/tmux-1000/default,1234,0')
+func TmuxSocket(env string) string {
+	socket, _, _ := strings.Cut(env, ",")
+	return socket
+}
+
+// TmuxPane asks the tmux server listening on the socket about the pane ('%3' of $TMUX_PANE)
+func TmuxPane(ctx context.Context, socket, paneID string) (*Pane, error) {
+	out, err := tmux(ctx, socket, "display-message", "-p", "-t", paneID, paneFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(strings.TrimRight(out, "\n"), "\t")
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("unexpected tmux output '%s'", out)
+	}
+	// some versions do not fail for the closed pane but expand the format to nothing
+	if fields[0] != paneID {
+		return nil, fmt.Errorf("tmux pane %s is closed", paneID)
+	}
+	attached, _ := strconv.Atoi(fields[4])
+	return &Pane{
+		Target:  fields[1],
+		Focused: fields[2] == "1" && fields[3] == "1" && attached > 0,
+	}, nil
+}
+
+// SelectTmuxPane makes the pane the active one of its window and session
+func SelectTmuxPane(ctx context.Context, socket, paneID string) error {
+	if _, err := tmux(ctx, socket, "select-window", "-t", paneID, ";", "select-pane", "-t", paneID); err != nil {
+		return err
+	}
+	// the client attached to another session (if any) is switched as well, nothing to do if there are no clients
+	_, _ = tmux(ctx, socket, "switch-client", "-t", paneID)
+	return nil
+}
+
+func tmux(ctx context.Context, socket string, args ...string) (string, error) {
+	if len(socket) != 0 {
+		args = append([]string{"-S", socket}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "tmux", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) != 0 {
+			return "", fmt.Errorf("tmux: %s", msg)
+		}
+		return "", fmt.Errorf("tmux: %w", err)
+	}
+	return string(out), nil
+}
@@ -6,26 +6,29 @@ import (
 	"io"
 
 	"github.com/oclaw/shnotify/notify"
+	"github.com/oclaw/shnotify/notify/render"
 	"github.com/oclaw/shnotify/types"
 )
 
 type cliNotifier struct {
-	out io.Writer
+	out      io.Writer
+	template *render.Template
 }
 
 var _ notify.Notifier = (*cliNotifier)(nil)
 
-func NewCliNotifier(stdout io.Writer) *cliNotifier {
+func NewCliNotifier(stdout io.Writer, template *render.Template) *cliNotifier {
 	return &cliNotifier{
-		out: stdout,
+		out:      stdout,
+		template: template,
 	}
 }
 
 func (cn *cliNotifier) Notify(_ context.Context, data *types.NotificationData) error {
-	fmt.Fprintf(cn.out, "Command %s '%s' was executing for a really long time (%d sec)\n",
-		data.Invocation.InvocationID,
-		data.Invocation.ShellLine,
-		data.ExecTime,
-	)
-	return nil
+	text, err := cn.template.Render(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(cn.out, text)
+	return err
 }
@@ -0,0 +1,156 @@
+package osc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/notify"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/types"
+)
+
+const (
+	title      = "shnotify"
+	maxTextLen = 256 // terminals and screen limit the length of the sequences
+)
+
+// DefaultTemplate is short since the terminals show a single line
+const DefaultTemplate = `
+{{- if eq .Kind "digest" -}}
+{{ len .Digest }} commands finished
+{{- else -}}
+{{ emoji . }} {{ truncate 80 .Invocation.ShellLine }} ({{ if ne .Kind "finished" }}{{ .Kind }}, {{ end }}{{ elapsed . }}{{ if .ExitCode }}, exit code {{ .ExitCode }}{{ end }}{{ with .Invocation.Context }}{{ with .Pane }}, {{ . }}{{ end }}{{ end }})
+{{- end }}`
+
+// oscNotifier writes the notification escape sequence to the terminal the command was started in,
+// the terminal emulator shows it as the desktop notification (even over ssh)
+type oscNotifier struct {
+	sequence  config.OSCSequence
+	machineID string
+	template  *render.Template
+}
+
+var _ notify.Notifier = (*oscNotifier)(nil)
+
+func NewOSCNotifier(options config.OSCOptions, machineID string, template *render.Template) *oscNotifier {
+	if len(options.Sequence) == 0 {
+		options.Sequence = config.OSC9
+	}
+	return &oscNotifier{
+		sequence:  options.Sequence,
+		machineID: machineID,
+		template:  template,
+	}
+}
+
+func (on *oscNotifier) Notify(_ context.Context, data *types.NotificationData) error {
+	text, err := on.template.Render(data)
+	if err != nil {
+		return err
+	}
+
+	// digest goes to every terminal of its commands
+	invocations := []*types.ShellInvocationRecord{data.Invocation}
+	if data.Kind == types.NotificationDigest {
+		invocations = invocations[:0]
+		for _, item := range data.Digest {
+			invocations = append(invocations, item.Invocation)
+		}
+	}
+
+	written := make(map[string]struct{})
+	var errs []error
+	for _, rec := range invocations {
+		if rec == nil || rec.Context == nil || len(rec.Context.TTY) == 0 || rec.MachineID != on.machineID {
+			continue
+		}
+		if _, ok := written[rec.Context.TTY]; ok {
+			continue
+		}
+		written[rec.Context.TTY] = struct{}{}
+		err := writeTerminal(rec.Context.TTY, wrap(rec.Context, on.escape(text)))
+		if errors.Is(err, os.ErrNotExist) {
+			// retries do not help the closed terminal
+			fmt.Printf("osc notifier: terminal %s is closed\n", rec.Context.TTY)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(written) == 0 {
+		// nothing to retry: the commands of the other machines or not started in a terminal
+		fmt.Printf("osc notifier: no local terminal to notify\n")
+	}
+	return errors.Join(errs...)
+}
+
+func (on *oscNotifier) escape(text string) string {
+	body := sanitize(text)
+	switch on.sequence {
+	case config.OSC777:
+		return "\x1b]777;notify;" + title + ";" + body + "\a"
+	case config.OSCBell:
+		return "\a"
+	default:
+		return "\x1b]9;" + body + "\a"
+	}
+}
+
+// wrap passes the sequence through the multiplexer to the outer terminal
+func wrap(ic *types.InvocationContext, seq string) string {
+	if seq == "\a" {
+		return seq // multiplexers forward the bell by themselves
+	}
+	switch {
+	case len(ic.TmuxPane) != 0:
+		// requires 'set -g allow-passthrough on' since tmux 3.3
+		return "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case len(ic.Screen) != 0:
+		return "\x1bP" + seq + "\x1b\\"
+	default:
+		return seq
+	}
+}
+
+// sanitize makes the message a single line without the characters terminating the sequence
+func sanitize(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return render.Truncate(maxTextLen, text)
+}
+
+// writeTerminal writes to the terminal device owned by the user of the daemon only, the recorded path comes from the client
+func writeTerminal(ttyPath, seq string) error {
+	if clean := filepath.Clean(ttyPath); clean != ttyPath || !strings.HasPrefix(ttyPath, "/dev/pts/") && !strings.HasPrefix(ttyPath, "/dev/tty") {
+		return fmt.Errorf("%s is not a terminal", ttyPath)
+	}
+	info, err := os.Stat(ttyPath)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if info.Mode()&os.ModeCharDevice == 0 || !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not a terminal of the user", ttyPath)
+	}
+
+	tty, err := os.OpenFile(ttyPath, os.O_WRONLY|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	_, err = tty.WriteString(seq)
+	return err
+}
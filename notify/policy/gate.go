@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/types"
+)
+
+// EmitFunc passes the notification further to the notifier
+type EmitFunc func(ctx context.Context, data *types.NotificationData) error
+
+// Gate applies the policy of the notifier instance: drops duplicates,
+// batches bursts into digests and limits the rate of the notifications.
+// The state is kept in memory so it makes sense for the long living daemon only.
+type Gate struct {
+	name    string
+	policy  config.NotificationPolicy
+	clock   common.Clock
+	emit    EmitFunc
+	timeout time.Duration
+
+	mu          sync.Mutex
+	sent        []int64          // timestamps of the emitted notifications within the rate limit window
+	lastSeen    map[string]int64 // last finish time per command line
+	windowStart int64
+	windowCount int
+	batched     []*types.NotificationData
+	flushTimer  *time.Timer
+}
+
+func NewGate(
+	name string,
+	policy config.NotificationPolicy,
+	clock common.Clock,
+	emit EmitFunc,
+	timeout time.Duration,
+) *Gate {
+	return &Gate{
+		name:     name,
+		policy:   policy,
+		clock:    clock,
+		emit:     emit,
+		timeout:  timeout,
+		lastSeen: make(map[string]int64),
+	}
+}
+
+func (g *Gate) Submit(ctx context.Context, data *types.NotificationData) error {
+	g.mu.Lock()
+
+	now := g.clock.NowUnix()
+
+	if g.duplicate(now, data) {
+		g.mu.Unlock()
+		fmt.Printf("notifier %s: dropping duplicate notification for '%s'\n", g.name, data.Invocation.ShellLine)
+		return nil
+	}
+
+	if g.batch(now, data) {
+		g.mu.Unlock()
+		return nil
+	}
+
+	allowed := g.allow(now)
+	g.mu.Unlock()
+
+	if !allowed {
+		fmt.Printf("notifier %s: rate limit exceeded, dropping notification for invocation %s\n", g.name, data.Invocation.InvocationID)
+		return nil
+	}
+	return g.emit(ctx, data)
+}
+
+// Flush sends the batched notifications right away (e.g. before the shutdown)
+func (g *Gate) Flush(ctx context.Context) error {
+	g.mu.Lock()
+	if g.flushTimer != nil {
+		g.flushTimer.Stop()
+		g.flushTimer = nil
+	}
+	batched := g.batched
+	g.batched = nil
+	allowed := len(batched) != 0 && g.allow(g.clock.NowUnix())
+	g.mu.Unlock()
+
+	if len(batched) == 0 {
+		return nil
+	}
+	if !allowed {
+		fmt.Printf("notifier %s: rate limit exceeded, dropping digest of %d notifications\n", g.name, len(batched))
+		return nil
+	}
+
+	return g.emit(ctx, &types.NotificationData{
+		Kind:         types.NotificationDigest,
+		NowTimestamp: g.clock.NowUnix(),
+		Digest:       batched,
+	})
+}
+
+func (g *Gate) duplicate(now int64, data *types.NotificationData) bool {
+	if g.policy.DedupWindow == nil || data.Kind != types.NotificationFinished {
+		return false
+	}
+
+	window := int64(time.Duration(*g.policy.DedupWindow).Seconds())
+	for key, seen := range g.lastSeen {
+		if now-seen > window {
+			delete(g.lastSeen, key)
+		}
+	}
+
+	key := data.Invocation.MachineID + "\x00" + data.Invocation.ShellLine
+	_, seen := g.lastSeen[key]
+	g.lastSeen[key] = now
+	return seen
+}
+
+// batch puts the notification into the pending digest if the burst threshold is exceeded
+func (g *Gate) batch(now int64, data *types.NotificationData) bool {
+	digest := g.policy.Digest
+	if digest == nil {
+		return false
+	}
+
+	window := time.Duration(digest.Window)
+	if now >= g.windowStart+int64(window.Seconds()) {
+		g.windowStart = now
+		g.windowCount = 0
+	}
+	g.windowCount++
+	if g.windowCount <= digest.Threshold {
+		return false
+	}
+
+	g.batched = append(g.batched, data)
+	if g.flushTimer == nil {
+		delay := time.Duration(g.windowStart+int64(window.Seconds())-now) * time.Second
+		g.flushTimer = time.AfterFunc(max(delay, 0), func() {
+			ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+			defer cancel()
+			if err := g.Flush(ctx); err != nil {
+				fmt.Printf("notifier %s: failed to send digest: %v\n", g.name, err)
+			}
+		})
+	}
+	return true
+}
+
+func (g *Gate) allow(now int64) bool {
+	limit := g.policy.RateLimit
+	if limit == nil {
+		return true
+	}
+
+	window := int64(time.Duration(limit.Window).Seconds())
+	actual := g.sent[:0]
+	for _, ts := range g.sent {
+		if now-ts < window {
+			actual = append(actual, ts)
+		}
+	}
+	g.sent = actual
+
+	if len(g.sent) >= limit.Max {
+		return false
+	}
+	g.sent = append(g.sent, now)
+	return true
+}
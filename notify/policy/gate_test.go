@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/oclaw/shnotify/common/clocktest"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/types"
+)
+
+type submission struct {
+	at      int64
+	machine string
+	line    string
+	kind    types.NotificationKind
+}
+
+func finished(at int64, line string) submission {
+	return submission{at: at, machine: "host", line: line, kind: types.NotificationFinished}
+}
+
+func duration(d time.Duration) *config.Duration {
+	cd := config.Duration(d)
+	return &cd
+}
+
+func TestGate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy config.NotificationPolicy
+		submit []submission
+		want   []int // indexes of the emitted submissions
+	}{
+		{
+			name:   "no policy",
+			submit: []submission{finished(0, "make"), finished(0, "make"), finished(0, "make")},
+			want:   []int{0, 1, 2},
+		},
+		{
+			name:   "rate limit within window",
+			policy: config.NotificationPolicy{RateLimit: &config.RateLimitPolicy{Max: 2, Window: config.Duration(time.Minute)}},
+			submit: []submission{finished(0, "a"), finished(1, "b"), finished(2, "c"), finished(59, "d")},
+			want:   []int{0, 1},
+		},
+		{
+			name:   "rate limit window slides",
+			policy: config.NotificationPolicy{RateLimit: &config.RateLimitPolicy{Max: 2, Window: config.Duration(time.Minute)}},
+			submit: []submission{finished(0, "a"), finished(30, "b"), finished(31, "c"), finished(60, "d"), finished(61, "e"), finished(90, "f")},
+			want:   []int{0, 1, 3, 5},
+		},
+		{
+			name:   "dropped notifications do not count",
+			policy: config.NotificationPolicy{RateLimit: &config.RateLimitPolicy{Max: 1, Window: config.Duration(time.Minute)}},
+			submit: []submission{finished(0, "a"), finished(10, "b"), finished(50, "c"), finished(60, "d")},
+			want:   []int{0, 3},
+		},
+		{
+			name:   "dedup of the same command",
+			policy: config.NotificationPolicy{DedupWindow: duration(10 * time.Second)},
+			submit: []submission{finished(0, "make"), finished(5, "make"), finished(6, "make test")},
+			want:   []int{0, 2},
+		},
+		{
+			name:   "dedup window is prolonged by the duplicates",
+			policy: config.NotificationPolicy{DedupWindow: duration(10 * time.Second)},
+			submit: []submission{finished(0, "make"), finished(8, "make"), finished(16, "make"), finished(27, "make")},
+			want:   []int{0, 3},
+		},
+		{
+			name:   "dedup per machine",
+			policy: config.NotificationPolicy{DedupWindow: duration(10 * time.Second)},
+			submit: []submission{
+				{at: 0, machine: "a", line: "make", kind: types.NotificationFinished},
+				{at: 1, machine: "b", line: "make", kind: types.NotificationFinished},
+			},
+			want: []int{0, 1},
+		},
+		{
+			name:   "dedup of finished notifications only",
+			policy: config.NotificationPolicy{DedupWindow: duration(10 * time.Second)},
+			submit: []submission{
+				{at: 0, machine: "host", line: "make", kind: types.NotificationInProgress},
+				{at: 1, machine: "host", line: "make", kind: types.NotificationInProgress},
+				finished(2, "make"),
+			},
+			want: []int{0, 1, 2},
+		},
+		{
+			name: "duplicates are not rate limited",
+			policy: config.NotificationPolicy{
+				RateLimit:   &config.RateLimitPolicy{Max: 2, Window: config.Duration(time.Minute)},
+				DedupWindow: duration(10 * time.Second),
+			},
+			submit: []submission{finished(0, "make"), finished(1, "make"), finished(2, "make test")},
+			want:   []int{0, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.New(0)
+			var emitted []string
+			gate := NewGate("test", tt.policy, clock, func(ctx context.Context, data *types.NotificationData) error {
+				emitted = append(emitted, string(data.Invocation.InvocationID))
+				return nil
+			}, time.Second)
+
+			var want []string
+			for i, s := range tt.submit {
+				id := types.InvocationID(rune('a' + i))
+				clock.Set(s.at)
+				err := gate.Submit(context.Background(), &types.NotificationData{
+					Kind: s.kind,
+					Invocation: &types.ShellInvocationRecord{
+						InvocationID: id,
+						MachineID:    s.machine,
+						ShellLine:    s.line,
+					},
+				})
+				if err != nil {
+					t.Fatalf("submit %d failed: %v", i, err)
+				}
+				if slices.Contains(tt.want, i) {
+					want = append(want, string(id))
+				}
+			}
+			if !slices.Equal(emitted, want) {
+				t.Errorf("emitted %v, expected %v", emitted, want)
+			}
+		})
+	}
+}
+
+func TestGateDigest(t *testing.T) {
+	clock := clocktest.New(0)
+	var emitted []*types.NotificationData
+	gate := NewGate("test", config.NotificationPolicy{
+		Digest: &config.DigestPolicy{Threshold: 1, Window: config.Duration(time.Hour)},
+	}, clock, func(ctx context.Context, data *types.NotificationData) error {
+		emitted = append(emitted, data)
+		return nil
+	}, time.Second)
+
+	for i, line := range []string{"a", "b", "c"} {
+		clock.Set(int64(i))
+		data := &types.NotificationData{
+			Kind:       types.NotificationFinished,
+			Invocation: &types.ShellInvocationRecord{ShellLine: line},
+		}
+		if err := gate.Submit(context.Background(), data); err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+	}
+	if len(emitted) != 1 || emitted[0].Invocation.ShellLine != "a" {
+		t.Fatalf("notifications above the threshold are expected to be batched, emitted %d", len(emitted))
+	}
+
+	if err := gate.Flush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if len(emitted) != 2 || emitted[1].Kind != types.NotificationDigest || len(emitted[1].Digest) != 2 {
+		t.Fatalf("digest of the batched notifications is expected on flush, emitted %+v", emitted)
+	}
+	if err := gate.Flush(context.Background()); err != nil || len(emitted) != 2 {
+		t.Errorf("nothing is expected on the second flush")
+	}
+}
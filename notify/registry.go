@@ -10,27 +10,33 @@ type Notifier interface {
 	Notify(context.Context, *types.NotificationData) error
 }
 
+// Registry holds notifier instances by their names
 type Registry struct {
-	notifiers map[types.NotificationType]Notifier
+	notifiers map[string]Notifier
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
-		notifiers: make(map[types.NotificationType]Notifier),
+		notifiers: make(map[string]Notifier),
 	}
 }
 
-func (rg *Registry) RegisterNotifier(nType types.NotificationType, impl Notifier) {
-	if _, exists := rg.notifiers[nType]; exists {
-		panic(fmt.Errorf("duplicate registration for %s\n", nType))
+func (rg *Registry) RegisterNotifier(name string, impl Notifier) {
+	if _, exists := rg.notifiers[name]; exists {
+		panic(fmt.Errorf("duplicate registration for %s\n", name))
 	}
-	rg.notifiers[nType] = impl
+	rg.notifiers[name] = impl
 }
 
-func (rg *Registry) GetNotifier(ctx context.Context, nType types.NotificationType) (Notifier, error) {
-	n, ok := rg.notifiers[nType]
+func (rg *Registry) Has(name string) bool {
+	_, ok := rg.notifiers[name]
+	return ok
+}
+
+func (rg *Registry) GetNotifier(ctx context.Context, name string) (Notifier, error) {
+	n, ok := rg.notifiers[name]
 	if !ok {
-		return nil, fmt.Errorf("notifier %s is not supported", nType)
+		return nil, fmt.Errorf("notifier %s is not supported", name)
 	}
 	return n, nil
 }
@@ -0,0 +1,140 @@
+package render
+
+const defaultPlain = `
+{{- if eq .Kind "digest" -}}
+{{ len .Digest }} commands finished:
+{{- range .Digest }}
+ - {{ if ne .Kind "finished" }}[{{ .Kind }}] {{ end }}'{{ truncate 100 .Invocation.ShellLine }}' ({{ elapsed . }}){{ if .ExitCode }} exit code {{ .ExitCode }}{{ end }}
+{{- end }}
+{{- else if eq .Kind "in_progress" -}}
+[in progress] Command {{ .Invocation.InvocationID }} '{{ .Invocation.ShellLine }}' is still running ({{ elapsed . }})
+{{- else if eq .Kind "abandoned" -}}
+[abandoned] Shell session died while running command {{ .Invocation.InvocationID }} '{{ .Invocation.ShellLine }}' after {{ elapsed . }}
+{{- else -}}
+Command {{ .Invocation.InvocationID }} '{{ .Invocation.ShellLine }}' was executing for a really long time ({{ elapsed . }})
+{{- if and .Invocation.Context .Invocation.Context.Cwd }}
+Directory: {{ .Invocation.Context.Cwd }}{{ with .Invocation.Context.GitBranch }} (git branch {{ . }}){{ end }}
+{{- end }}
+{{- if and .Invocation.Context .Invocation.Context.Pane }}
+Pane: {{ .Invocation.Context.Pane }}
+{{- end }}
+{{- if .ExitCode }}
+Exit code: {{ .ExitCode }}
+{{- end }}
+{{- if .OutputTail }}
+Output tail:
+{{ .OutputTail }}
+{{- end }}
+{{- end }}
+`
+
+const defaultMarkdown = `shnotify update
+{{ if eq .Kind "digest" -}}
+{{ emoji . }} *{{ len .Digest }} commands finished:*
+{{- range .Digest }}
+- {{ emoji . }} *{{ esc (truncate 100 .Invocation.ShellLine) }}* ({{ elapsed . }}, {{ esc .Invocation.MachineID }})
+{{- end }}
+{{- else -}}
+{{ if eq .Kind "in_progress" -}}
+{{ emoji . }} *In progress*: command *{{ esc (truncate 200 .Invocation.ShellLine) }}* is still running
+{{- else if eq .Kind "abandoned" -}}
+{{ emoji . }} *Abandoned*: shell session died while running command *{{ esc (truncate 200 .Invocation.ShellLine) }}*
+{{- else -}}
+{{ emoji . }} Command *{{ esc (truncate 200 .Invocation.ShellLine) }}* has finished its execution
+{{- end }}
+- machine: *{{ esc .Invocation.MachineID }}*
+{{- if and .Invocation.Context .Invocation.Context.Cwd }}
+- directory: *{{ esc .Invocation.Context.Cwd }}*{{ with .Invocation.Context.GitBranch }} (branch *{{ esc . }}*){{ end }}
+{{- end }}
+{{- if and .Invocation.Context .Invocation.Context.Pane }}
+- pane: *{{ esc .Invocation.Context.Pane }}*
+{{- end }}
+- invocation-id: *{{ esc (print .Invocation.InvocationID) }}*
+- {{ if eq .Kind "finished" }}execution time{{ else }}running for{{ end }}: *{{ elapsed . }}*
+{{- if .ExitCode }}
+- exit code: *{{ .ExitCode }}*
+{{- end }}
+{{- if .OutputTail }}
+{{ code .OutputTail }}
+{{- end }}
+{{- end }}
+`
+
+// static text of markdown v2 template must be escaped as well
+const defaultMarkdownV2 = `shnotify update
+{{ if eq .Kind "digest" -}}
+{{ emoji . }} *{{ len .Digest }} commands finished:*
+{{- range .Digest }}
+\- {{ emoji . }} *{{ esc (truncate 100 .Invocation.ShellLine) }}* \({{ esc (elapsed .) }}, {{ esc .Invocation.MachineID }}\)
+{{- end }}
+{{- else -}}
+{{ if eq .Kind "in_progress" -}}
+{{ emoji . }} *In progress*: command *{{ esc (truncate 200 .Invocation.ShellLine) }}* is still running
+{{- else if eq .Kind "abandoned" -}}
+{{ emoji . }} *Abandoned*: shell session died while running command *{{ esc (truncate 200 .Invocation.ShellLine) }}*
+{{- else -}}
+{{ emoji . }} Command *{{ esc (truncate 200 .Invocation.ShellLine) }}* has finished its execution
+{{- end }}
+\- machine: *{{ esc .Invocation.MachineID }}*
+{{- if and .Invocation.Context .Invocation.Context.Cwd }}
+\- directory: *{{ esc .Invocation.Context.Cwd }}*{{ with .Invocation.Context.GitBranch }} \(branch *{{ esc . }}*\){{ end }}
+{{- end }}
+{{- if and .Invocation.Context .Invocation.Context.Pane }}
+\- pane: *{{ esc .Invocation.Context.Pane }}*
+{{- end }}
+\- invocation\-id: *{{ esc (print .Invocation.InvocationID) }}*
+\- {{ if eq .Kind "finished" }}execution time{{ else }}running for{{ end }}: *{{ esc (elapsed .) }}*
+{{- if .ExitCode }}
+\- exit code: *{{ .ExitCode }}*
+{{- end }}
+{{- if .OutputTail }}
+{{ code .OutputTail }}
+{{- end }}
+{{- end }}
+`
+
+const defaultHTML = `shnotify update
+{{ if eq .Kind "digest" -}}
+{{ emoji . }} <b>{{ len .Digest }} commands finished:</b>
+{{- range .Digest }}
+- {{ emoji . }} <b>{{ esc (truncate 100 .Invocation.ShellLine) }}</b> ({{ elapsed . }}, {{ esc .Invocation.MachineID }})
+{{- end }}
+{{- else -}}
+{{ if eq .Kind "in_progress" -}}
+{{ emoji . }} <b>In progress</b>: command <b>{{ esc (truncate 200 .Invocation.ShellLine) }}</b> is still running
+{{- else if eq .Kind "abandoned" -}}
+{{ emoji . }} <b>Abandoned</b>: shell session died while running command <b>{{ esc (truncate 200 .Invocation.ShellLine) }}</b>
+{{- else -}}
+{{ emoji . }} Command <b>{{ esc (truncate 200 .Invocation.ShellLine) }}</b> has finished its execution
+{{- end }}
+- machine: <b>{{ esc .Invocation.MachineID }}</b>
+{{- if and .Invocation.Context .Invocation.Context.Cwd }}
+- directory: <b>{{ esc .Invocation.Context.Cwd }}</b>{{ with .Invocation.Context.GitBranch }} (branch <b>{{ esc . }}</b>){{ end }}
+{{- end }}
+{{- if and .Invocation.Context .Invocation.Context.Pane }}
+- pane: <b>{{ esc .Invocation.Context.Pane }}</b>
+{{- end }}
+- invocation-id: <b>{{ esc (print .Invocation.InvocationID) }}</b>
+- {{ if eq .Kind "finished" }}execution time{{ else }}running for{{ end }}: <b>{{ elapsed . }}</b>
+{{- if .ExitCode }}
+- exit code: <b>{{ .ExitCode }}</b>
+{{- end }}
+{{- if .OutputTail }}
+{{ code .OutputTail }}
+{{- end }}
+{{- end }}
+`
+
+// DefaultTemplate returns the template shipped for the format
+func DefaultTemplate(format Format) string {
+	switch format {
+	case FormatMarkdown:
+		return defaultMarkdown
+	case FormatMarkdownV2:
+		return defaultMarkdownV2
+	case FormatHTML:
+		return defaultHTML
+	default:
+		return defaultPlain
+	}
+}
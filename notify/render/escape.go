@@ -0,0 +1,69 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Format is the markup of the target the notification is rendered for
+type Format string
+
+const (
+	FormatPlain      Format = "plain"
+	FormatMarkdown   Format = "markdown"   // legacy telegram markdown
+	FormatMarkdownV2 Format = "markdownv2" // telegram markdown v2
+	FormatHTML       Format = "html"
+)
+
+func ParseFormat(raw string) (Format, error) {
+	switch f := Format(strings.ToLower(raw)); f {
+	case FormatPlain, FormatMarkdown, FormatMarkdownV2, FormatHTML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format '%s'", raw)
+	}
+}
+
+var (
+	markdownEscaper   = strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`)
+	markdownV2Escaper = newEscaper(`\_*[]()~` + "`" + `>#+-=|{}.!`)
+	markdownV2Code    = newEscaper(`\` + "`")
+)
+
+func newEscaper(chars string) *strings.Replacer {
+	pairs := make([]string, 0, len(chars)*2)
+	for _, ch := range chars {
+		pairs = append(pairs, string(ch), `\`+string(ch))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Escape makes the text safe to be put into the message of the format
+func Escape(format Format, text string) string {
+	switch format {
+	case FormatMarkdown:
+		return markdownEscaper.Replace(text)
+	case FormatMarkdownV2:
+		return markdownV2Escaper.Replace(text)
+	case FormatHTML:
+		return html.EscapeString(text)
+	default:
+		return text
+	}
+}
+
+// CodeBlock renders the text as preformatted block of the format
+func CodeBlock(format Format, text string) string {
+	switch format {
+	case FormatMarkdown:
+		// code block cannot be escaped in legacy markdown, just break the fences inside of it
+		return "```\n" + strings.ReplaceAll(text, "```", "'''") + "\n```"
+	case FormatMarkdownV2:
+		return "```\n" + markdownV2Code.Replace(text) + "\n```"
+	case FormatHTML:
+		return "<pre>" + html.EscapeString(text) + "</pre>"
+	default:
+		return text
+	}
+}
@@ -0,0 +1,48 @@
+package render
+
+import "testing"
+
+const specials = "*_[]()~`>#+-=|{}.!"
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		format Format
+		text   string
+		want   string
+	}{
+		{FormatPlain, specials, specials},
+		{FormatMarkdown, specials, `\*\_\[]()~\` + "`" + `>#+-=|{}.!`},
+		{FormatMarkdownV2, specials, `\*\_\[\]\(\)\~\` + "`" + `\>\#\+\-\=\|\{\}\.\!`},
+		{FormatMarkdownV2, `C:\temp`, `C:\\temp`},
+		{FormatHTML, `<b>"a" & 'b'</b>`, `&lt;b&gt;&#34;a&#34; &amp; &#39;b&#39;&lt;/b&gt;`},
+		{FormatHTML, specials, "*_[]()~`&gt;#+-=|{}.!"},
+		{FormatMarkdownV2, "plain text", "plain text"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format)+" "+tt.text, func(t *testing.T) {
+			if got := Escape(tt.format, tt.text); got != tt.want {
+				t.Errorf("Escape(%s, %q) = %q, expected %q", tt.format, tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeBlock(t *testing.T) {
+	tests := []struct {
+		format Format
+		text   string
+		want   string
+	}{
+		{FormatPlain, "a ``` b", "a ``` b"},
+		{FormatMarkdown, "a ``` b *c*", "```\na ''' b *c*\n```"},
+		{FormatMarkdownV2, "a ``` b " + `\n` + " *c*.", "```\na \\`\\`\\` b \\\\n *c*.\n```"},
+		{FormatHTML, "<pre>a & b</pre>", "<pre>&lt;pre&gt;a &amp; b&lt;/pre&gt;</pre>"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			if got := CodeBlock(tt.format, tt.text); got != tt.want {
+				t.Errorf("CodeBlock(%s, %q) = %q, expected %q", tt.format, tt.text, got, tt.want)
+			}
+		})
+	}
+}
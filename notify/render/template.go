@@ -0,0 +1,166 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+// Template renders notification text for the target format
+type Template struct {
+	format Format
+	tmpl   *template.Template
+}
+
+// New parses the template text, the default template of the format is used if the text is empty
+func New(name, text string, format Format) (*Template, error) {
+	if len(text) == 0 {
+		text = DefaultTemplate(format)
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs(format)).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template '%s': %w", name, err)
+	}
+	return &Template{
+		format: format,
+		tmpl:   tmpl,
+	}, nil
+}
+
+// NewFromFile parses the template stored in the file
+func NewFromFile(name, filePath string, format Format) (*Template, error) {
+	text, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return New(name, string(text), format)
+}
+
+func (t *Template) Format() Format {
+	return t.format
+}
+
+func (t *Template) Render(data *types.NotificationData) (string, error) {
+	var out strings.Builder
+	if err := t.tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func funcs(format Format) template.FuncMap {
+	return template.FuncMap{
+		"esc": func(text string) string {
+			return Escape(format, text)
+		},
+		"escape": func(target, text string) (string, error) {
+			f, err := ParseFormat(target)
+			if err != nil {
+				return "", err
+			}
+			return Escape(f, text), nil
+		},
+		"code": func(text string) string {
+			return CodeBlock(format, text)
+		},
+		"duration": HumanDuration,
+		"elapsed": func(data *types.NotificationData) string {
+			return PreciseDuration(ExecTimeMs(data))
+		},
+		"reltime": RelativeTime,
+		"time": func(ts int64) string {
+			return time.Unix(ts, 0).Format(time.DateTime)
+		},
+		"truncate": Truncate,
+		"emoji":    StatusEmoji,
+	}
+}
+
+// HumanDuration formats seconds as '1h 2m 3s'
+func HumanDuration(sec int64) string {
+	if sec <= 0 {
+		return "0s"
+	}
+	d := time.Duration(sec) * time.Second
+	parts := make([]string, 0, 4)
+	if days := d / (24 * time.Hour); days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+		d -= days * 24 * time.Hour
+	}
+	if hours := d / time.Hour; hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+		d -= hours * time.Hour
+	}
+	if minutes := d / time.Minute; minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+		d -= minutes * time.Minute
+	}
+	if seconds := d / time.Second; seconds > 0 {
+		parts = append(parts, fmt.Sprintf("%ds", seconds))
+	}
+	return strings.Join(parts, " ")
+}
+
+// PreciseDuration formats milliseconds keeping the fraction of a second for the short durations ('350ms', '2.35s')
+func PreciseDuration(ms int64) string {
+	switch {
+	case ms <= 0:
+		return "0s"
+	case ms < 1000:
+		return fmt.Sprintf("%dms", ms)
+	case ms < int64(time.Minute/time.Millisecond):
+		return (time.Duration(ms) * time.Millisecond).Round(10 * time.Millisecond).String()
+	default:
+		return HumanDuration(ms / 1000)
+	}
+}
+
+// ExecTimeMs returns the execution time in milliseconds, the notifications of the older versions carry seconds only
+func ExecTimeMs(data *types.NotificationData) int64 {
+	if data.ExecTimeMs != 0 {
+		return data.ExecTimeMs
+	}
+	return data.ExecTime * 1000
+}
+
+// RelativeTime formats ts relatively to now ('5m ago', 'in 1h')
+func RelativeTime(ts, now int64) string {
+	switch diff := now - ts; {
+	case diff == 0:
+		return "just now"
+	case diff > 0:
+		return HumanDuration(diff) + " ago"
+	default:
+		return "in " + HumanDuration(-diff)
+	}
+}
+
+// Truncate cuts the text to n runes marking the cut with ellipsis
+func Truncate(n int, text string) string {
+	if n <= 0 || utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:max(n-1, 0)]) + "…"
+}
+
+func StatusEmoji(data *types.NotificationData) string {
+	switch data.Kind {
+	case types.NotificationInProgress:
+		return "⏳"
+	case types.NotificationAbandoned:
+		return "💀"
+	case types.NotificationDigest:
+		return "📦"
+	}
+	if data.ExitCode != nil && *data.ExitCode != 0 {
+		return "❌"
+	}
+	return "✅"
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+	"html"
+	"strings"
+	"testing"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+// shellLine has every character telegram markdown v2 requires to be escaped
+const shellLine = "rm -rf ./build_*/[a-z]{1,2}.o && echo \"(done) ~ `date` > #1 + a=b | c!\" <x>"
+
+func testData(kind types.NotificationKind) *types.NotificationData {
+	exitCode := 2
+	rec := &types.ShellInvocationRecord{
+		InvocationID: "2807f194-cb60-11f1",
+		MachineID:    "host-1.local",
+		ShellLine:    shellLine,
+		Context: &types.InvocationContext{
+			Cwd:       "/tmp/a_b (1)",
+			GitBranch: "fix/#12-x.y",
+			Pane:      "main:1.2",
+		},
+	}
+	data := &types.NotificationData{
+		Kind:       kind,
+		Invocation: rec,
+		ExecTime:   2,
+		ExecTimeMs: 2350,
+	}
+	if kind == types.NotificationFinished {
+		data.ExitCode = &exitCode
+		data.OutputTail = "```\n\\ `x` *y*"
+	}
+	if kind == types.NotificationDigest {
+		first, second := testData(types.NotificationFinished), testData(types.NotificationAbandoned)
+		data.Invocation = first.Invocation
+		data.Digest = []*types.NotificationData{first, second}
+	}
+	return data
+}
+
+// checkMarkdownV2 reports the characters telegram rejects unescaped, '*' is the only markup of the default templates
+func checkMarkdownV2(t *testing.T, text string) {
+	t.Helper()
+	inCode := false
+	bold := 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "```"):
+			inCode = !inCode
+			i += 2
+		case text[i] == '\\':
+			i++ // escaped
+		case inCode:
+			if text[i] == '`' {
+				t.Errorf("unescaped '`' in the code block at %d: %q", i, text)
+			}
+		case text[i] == '*':
+			bold++
+		case strings.IndexByte(`_[]()~`+"`"+`>#+-=|{}.!`, text[i]) >= 0:
+			t.Errorf("unescaped '%c' at %d: %q", text[i], i, text[max(i-20, 0):min(i+20, len(text))])
+		}
+	}
+	if inCode || bold%2 != 0 {
+		t.Errorf("unbalanced markup (code %v, %d asterisks): %q", inCode, bold, text)
+	}
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	kinds := []types.NotificationKind{
+		types.NotificationFinished,
+		types.NotificationInProgress,
+		types.NotificationAbandoned,
+		types.NotificationDigest,
+	}
+	for _, format := range []Format{FormatPlain, FormatMarkdown, FormatMarkdownV2, FormatHTML} {
+		tmpl, err := New("default", "", format)
+		if err != nil {
+			t.Fatalf("default %s template: %v", format, err)
+		}
+		for _, kind := range kinds {
+			t.Run(string(format)+" "+string(kind), func(t *testing.T) {
+				text, err := tmpl.Render(testData(kind))
+				if err != nil {
+					t.Fatalf("failed to render: %v", err)
+				}
+
+				switch format {
+				case FormatPlain:
+					if !strings.Contains(text, shellLine) {
+						t.Errorf("command line is not rendered as is: %q", text)
+					}
+				case FormatMarkdown:
+					if !strings.Contains(text, markdownEscaper.Replace(shellLine)) {
+						t.Errorf("command line is not escaped: %q", text)
+					}
+				case FormatMarkdownV2:
+					checkMarkdownV2(t, text)
+				case FormatHTML:
+					if !strings.Contains(text, html.EscapeString(shellLine)) {
+						t.Errorf("command line is not escaped: %q", text)
+					}
+					if strings.Contains(text, "<x>") {
+						t.Errorf("markup of the command line is not escaped: %q", text)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n    int
+		text string
+		want string
+	}{
+		{5, "hello", "hello"},
+		{4, "hello", "hel…"},
+		{3, "привет", "пр…"},
+		{0, "hello", "hello"},
+	}
+	for _, tt := range tests {
+		if got := Truncate(tt.n, tt.text); got != tt.want {
+			t.Errorf("Truncate(%d, %q) = %q, expected %q", tt.n, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/secrets"
+)
+
+const requestTimeout = 30 * time.Second
+
+// baseURLTransport sends the Bot API requests to the configured server (e.g. local Bot API server or a fake for tests),
+// the library has the endpoint hardcoded
+type baseURLTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.base != nil {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = t.base.Scheme
+		req.URL.Host = t.base.Host
+		req.URL.Path = strings.TrimSuffix(t.base.Path, "/") + req.URL.Path
+		req.Host = t.base.Host
+	}
+	return t.next.RoundTrip(req)
+}
+
+// ParseAPIURL checks the Bot API server address, empty one stands for the default server
+func ParseAPIURL(raw string) (*url.URL, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	base, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || len(base.Host) == 0 {
+		return nil, fmt.Errorf("invalid telegram api url '%s', http(s)://host[:port][/path] expected", raw)
+	}
+	return base, nil
+}
+
+// newClient creates the Bot API client without the request checking the token,
+// the library does not take the context so every request is limited by the timeout
+func newClient(token, apiURL string, timeout time.Duration) (*tgbotapi.BotAPI, error) {
+	base, err := ParseAPIURL(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	return &tgbotapi.BotAPI{
+		Token: strings.TrimSpace(token),
+		Client: &http.Client{
+			Transport: &baseURLTransport{
+				base: base,
+				next: http.DefaultTransport,
+			},
+			Timeout: timeout,
+		},
+		Buffer: 100,
+	}, nil
+}
+
+// newBotAPI creates the Bot API client checking the token with getMe request
+func newBotAPI(token, apiURL string) (*tgbotapi.BotAPI, error) {
+	bot, err := newClient(token, apiURL, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if bot.Self, err = bot.GetMe(); err != nil {
+		return nil, scrub(err, bot.Token)
+	}
+	return bot, nil
+}
+
+// ResolveToken returns the bot token the settings reference
+func ResolveToken(ctx context.Context, settings *config.NotifierSettings) (string, error) {
+	ref := settings.TelegramToken
+	if len(ref) == 0 {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		ref = config.Secret("file:" + path.Join(dir, "shnotify", ".tg.token"))
+	}
+	return secrets.Resolve(ctx, ref)
+}
@@ -0,0 +1,409 @@
+package telegram
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/types"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	pollRetryDelay     = 5 * time.Second
+	confirmTimeout     = time.Minute
+
+	defaultHistoryItems = 10
+	maxHistoryItems     = 50
+	defaultMute         = time.Hour
+	defaultStatsPeriod  = 24 * time.Hour
+
+	maxMessageLen = 4096
+	shortIDLen    = 8
+)
+
+// Controller is the part of the tracker the bot commands are executed with
+type Controller interface {
+	ListInvocations(ctx context.Context) ([]types.RunningInvocation, error)
+	History(ctx context.Context, limit int) ([]types.HistoryEntry, error)
+	Stats(ctx context.Context, since int64) (*types.Stats, error)
+	Mute(ctx context.Context, until int64) error
+	Kill(ctx context.Context, id types.InvocationID) (*types.ShellInvocationRecord, error)
+}
+
+// Bot answers the commands sent to the chat by the allowed users
+type Bot struct {
+	api         *tgbotapi.BotAPI
+	chatID      int64
+	allowed     []int64
+	pollTimeout time.Duration
+	control     Controller
+	clock       common.Clock
+
+	confirmations map[string]confirmation // pending /kill requests by the nonce, accessed by the polling loop only
+}
+
+type confirmation struct {
+	id        types.InvocationID
+	userID    int64
+	expiresAt int64
+}
+
+func NewBot(
+	token string,
+	settings *config.NotifierSettings,
+	control Controller,
+	clock common.Clock,
+) (*Bot, error) {
+
+	if settings.TelegramBot == nil {
+		return nil, errors.New("telegram bot is not configured")
+	}
+	pollTimeout := time.Duration(settings.TelegramBot.PollTimeout)
+	if pollTimeout <= 0 {
+		pollTimeout = defaultPollTimeout
+	}
+
+	// long polling request is held by the server for the poll timeout
+	api, err := newClient(token, settings.TelegramAPIURL, pollTimeout+requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bot{
+		api:           api,
+		chatID:        settings.TelegramChatID,
+		allowed:       settings.TelegramBot.AllowedUsers,
+		pollTimeout:   pollTimeout,
+		control:       control,
+		clock:         clock,
+		confirmations: make(map[string]confirmation),
+	}, nil
+}
+
+// Run polls the updates until the context is cancelled
+func (b *Bot) Run(ctx context.Context) error {
+	offset := 0
+	for {
+		updates, err := b.api.GetUpdates(tgbotapi.UpdateConfig{
+			Offset:  offset,
+			Timeout: int(b.pollTimeout.Seconds()),
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			fmt.Printf("telegram bot: failed to get updates: %v\n", scrub(err, b.api.Token))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pollRetryDelay):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			switch {
+			case update.Message != nil:
+				b.handleMessage(ctx, update.Message)
+			case update.CallbackQuery != nil:
+				b.handleCallback(ctx, update.CallbackQuery)
+			}
+		}
+	}
+}
+
+// authorized checks that the update comes from the configured chat and the allowed user
+func (b *Bot) authorized(chat *tgbotapi.Chat, user *tgbotapi.User) bool {
+	if chat == nil || user == nil || chat.ID != b.chatID {
+		return false
+	}
+	if !slices.Contains(b.allowed, int64(user.ID)) {
+		fmt.Printf("telegram bot: ignoring command of not allowed user %d\n", user.ID)
+		return false
+	}
+	return true
+}
+
+func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
+	if !b.authorized(msg.Chat, msg.From) || !strings.HasPrefix(msg.Text, "/") {
+		return
+	}
+
+	fields := strings.Fields(msg.Text)
+	command, _, _ := strings.Cut(fields[0], "@") // commands in groups are suffixed with the bot name
+	args := fields[1:]
+
+	var (
+		reply string
+		err   error
+	)
+	switch command {
+	case "/ps":
+		reply, err = b.ps(ctx)
+	case "/history":
+		reply, err = b.history(ctx, args)
+	case "/stats":
+		reply, err = b.stats(ctx, args)
+	case "/mute":
+		reply, err = b.mute(ctx, args)
+	case "/unmute":
+		err = b.control.Mute(ctx, 0)
+		reply = "Notifications are unmuted"
+	case "/kill":
+		b.askKill(ctx, msg, args)
+		return
+	case "/start", "/help":
+		reply = helpText
+	default:
+		reply = "Unknown command\n\n" + helpText
+	}
+	if err != nil {
+		reply = fmt.Sprintf("Failed: %v", err)
+	}
+	b.send(tgbotapi.NewMessage(b.chatID, reply))
+}
+
+const helpText = `/ps - running commands
+/history [n] - last finished commands
+/stats [24h] - summary of the period
+/mute [1h] - pause notifications
+/unmute - resume notifications
+/kill <id> - terminate the command started with 'shnotify run'`
+
+func (b *Bot) ps(ctx context.Context) (string, error) {
+	running, err := b.control.ListInvocations(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(running) == 0 {
+		return "No running commands", nil
+	}
+
+	var text strings.Builder
+	for _, inv := range running {
+		rec := inv.Record
+		fmt.Fprintf(&text, "%s %s %s", shortID(rec.InvocationID), render.HumanDuration(inv.ElapsedTime), render.Truncate(100, rec.ShellLine))
+		if rec.ChildPID != 0 {
+			text.WriteString(" [killable]")
+		}
+		if len(rec.MachineID) != 0 {
+			fmt.Fprintf(&text, " @%s", rec.MachineID)
+		}
+		text.WriteString("\n")
+	}
+	return text.String(), nil
+}
+
+func (b *Bot) history(ctx context.Context, args []string) (string, error) {
+	limit := defaultHistoryItems
+	if len(args) != 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid number of items '%s'", args[0])
+		}
+		limit = min(n, maxHistoryItems)
+	}
+
+	entries, err := b.control.History(ctx, limit)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "History is empty", nil
+	}
+
+	now := b.clock.NowUnix()
+	var text strings.Builder
+	for _, entry := range entries {
+		status := "ok"
+		switch {
+		case entry.Abandoned:
+			status = "abandoned"
+		case entry.ExitCode != nil && *entry.ExitCode != 0:
+			status = fmt.Sprintf("exit %d", *entry.ExitCode)
+		}
+		fmt.Fprintf(&text, "%s %s (%s, %s) %s\n",
+			render.RelativeTime(entry.FinishedAt, now),
+			render.Truncate(100, entry.ShellLine),
+			render.PreciseDuration(cmp.Or(entry.ExecTimeMs, entry.ExecTime*1000)),
+			status,
+			shortID(entry.InvocationID),
+		)
+	}
+	return text.String(), nil
+}
+
+func (b *Bot) stats(ctx context.Context, args []string) (string, error) {
+	period := defaultStatsPeriod
+	if len(args) != 0 {
+		var err error
+		if period, err = time.ParseDuration(args[0]); err != nil || period <= 0 {
+			return "", fmt.Errorf("invalid period '%s'", args[0])
+		}
+	}
+
+	stats, err := b.control.Stats(ctx, b.clock.NowUnix()-int64(period.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Last %s:\n", render.HumanDuration(int64(period.Seconds())))
+	fmt.Fprintf(&text, "running: %d\nfinished: %d (failed %d)\nabandoned: %d\n", stats.Running, stats.Finished, stats.Failed, stats.Abandoned)
+	if stats.Finished != 0 {
+		fmt.Fprintf(&text, "total time: %s, longest: %s\n", render.HumanDuration(stats.TotalExecTime), render.HumanDuration(stats.MaxExecTime))
+	}
+	if len(stats.Top) != 0 {
+		text.WriteString("\nTop commands:\n")
+		for _, cs := range stats.Top {
+			fmt.Fprintf(&text, "%s - %d run(s), %s\n", cs.Command, cs.Count, render.HumanDuration(cs.TotalExecTime))
+		}
+	}
+	return text.String(), nil
+}
+
+func (b *Bot) mute(ctx context.Context, args []string) (string, error) {
+	period := defaultMute
+	if len(args) != 0 {
+		var err error
+		if period, err = time.ParseDuration(args[0]); err != nil || period <= 0 {
+			return "", fmt.Errorf("invalid duration '%s'", args[0])
+		}
+	}
+	if err := b.control.Mute(ctx, b.clock.NowUnix()+int64(period.Seconds())); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Notifications are muted for %s, /unmute to resume", render.HumanDuration(int64(period.Seconds()))), nil
+}
+
+// askKill finds the running command by the id prefix and asks the user to confirm the termination
+func (b *Bot) askKill(ctx context.Context, msg *tgbotapi.Message, args []string) {
+	reply := func(text string) {
+		b.send(tgbotapi.NewMessage(b.chatID, text))
+	}
+	if len(args) != 1 {
+		reply("Usage: /kill <id>, ids are listed by /ps")
+		return
+	}
+
+	running, err := b.control.ListInvocations(ctx)
+	if err != nil {
+		reply(fmt.Sprintf("Failed: %v", err))
+		return
+	}
+	var matched []*types.ShellInvocationRecord
+	for _, inv := range running {
+		if strings.HasPrefix(string(inv.Record.InvocationID), args[0]) {
+			matched = append(matched, inv.Record)
+		}
+	}
+	switch {
+	case len(matched) == 0:
+		reply(fmt.Sprintf("No running command with id %s", args[0]))
+		return
+	case len(matched) > 1:
+		reply(fmt.Sprintf("Id %s matches %d commands, use the longer prefix", args[0], len(matched)))
+		return
+	case matched[0].ChildPID == 0:
+		reply("Only the commands started with 'shnotify run' can be killed")
+		return
+	}
+
+	b.expireConfirmations()
+	nonce, err := newNonce()
+	if err != nil {
+		reply(fmt.Sprintf("Failed: %v", err))
+		return
+	}
+	b.confirmations[nonce] = confirmation{
+		id:        matched[0].InvocationID,
+		userID:    int64(msg.From.ID),
+		expiresAt: b.clock.NowUnix() + int64(confirmTimeout.Seconds()),
+	}
+
+	ask := tgbotapi.NewMessage(b.chatID, fmt.Sprintf("Kill '%s' (%s)?", render.Truncate(100, matched[0].ShellLine), shortID(matched[0].InvocationID)))
+	ask.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Kill", "kill:"+nonce),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", "cancel:"+nonce),
+	))
+	b.send(ask)
+}
+
+func (b *Bot) handleCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	if query.Message == nil || !b.authorized(query.Message.Chat, query.From) {
+		return
+	}
+
+	b.expireConfirmations()
+	action, nonce, _ := strings.Cut(query.Data, ":")
+	pending, ok := b.confirmations[nonce]
+	if !ok || pending.userID != int64(query.From.ID) {
+		b.answer(query.ID, "The request is expired or belongs to the other user")
+		return
+	}
+	delete(b.confirmations, nonce)
+
+	result := "Cancelled"
+	if action == "kill" {
+		if _, err := b.control.Kill(ctx, pending.id); err != nil {
+			result = fmt.Sprintf("Failed: %v", err)
+		} else {
+			result = fmt.Sprintf("Command %s is terminated", shortID(pending.id))
+		}
+	}
+	b.answer(query.ID, "")
+	b.send(tgbotapi.NewEditMessageText(b.chatID, query.Message.MessageID, query.Message.Text+"\n"+result))
+}
+
+func (b *Bot) expireConfirmations() {
+	now := b.clock.NowUnix()
+	for nonce, pending := range b.confirmations {
+		if pending.expiresAt < now {
+			delete(b.confirmations, nonce)
+		}
+	}
+}
+
+func (b *Bot) send(msg tgbotapi.Chattable) {
+	if text, ok := msg.(tgbotapi.MessageConfig); ok {
+		text.Text = render.Truncate(maxMessageLen, text.Text)
+		msg = text
+	}
+	if _, err := b.api.Send(msg); err != nil {
+		fmt.Printf("telegram bot: failed to reply: %v\n", scrub(err, b.api.Token))
+	}
+}
+
+func (b *Bot) answer(queryID, text string) {
+	if _, err := b.api.AnswerCallbackQuery(tgbotapi.NewCallback(queryID, text)); err != nil {
+		fmt.Printf("telegram bot: failed to answer callback: %v\n", scrub(err, b.api.Token))
+	}
+}
+
+func shortID(id types.InvocationID) string {
+	if len(id) > shortIDLen {
+		return string(id[:shortIDLen])
+	}
+	return string(id)
+}
+
+func newNonce() (string, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/types"
+)
+
+// messages are kept for the invocations which are never finalized (e.g. notifications were dropped by the policies)
+const staleMessageAge = 7 * 24 * time.Hour
+
+// messageStore keeps the ids of the live messages per invocation, they survive the restart of the daemon
+type messageStore struct {
+	dirPath string
+}
+
+func newMessageStore(dirPath string) (*messageStore, error) {
+	if err := os.MkdirAll(dirPath, 0o700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > staleMessageAge {
+			_ = os.Remove(path.Join(dirPath, entry.Name()))
+		}
+	}
+
+	return &messageStore{
+		dirPath: dirPath,
+	}, nil
+}
+
+func (ms *messageStore) get(id types.InvocationID) (int, bool) {
+	raw, err := os.ReadFile(ms.filePath(id))
+	if err != nil {
+		return 0, false
+	}
+	msgID, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	return msgID, err == nil
+}
+
+func (ms *messageStore) put(id types.InvocationID, msgID int) error {
+	return os.WriteFile(ms.filePath(id), []byte(strconv.Itoa(msgID)), 0o600)
+}
+
+func (ms *messageStore) remove(id types.InvocationID) {
+	if err := os.Remove(ms.filePath(id)); common.IgnoreErr(err, os.ErrNotExist) != nil {
+		fmt.Printf("failed to remove live message of invocation %s: %v\n", id, err)
+	}
+}
+
+func (ms *messageStore) filePath(id types.InvocationID) string {
+	return path.Join(ms.dirPath, string(id))
+}
@@ -2,50 +2,191 @@ package telegram
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
-	"github.com/nikoksr/notify/service/telegram"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/oclaw/shnotify/config"
 	"github.com/oclaw/shnotify/notify"
+	"github.com/oclaw/shnotify/notify/render"
 	"github.com/oclaw/shnotify/types"
 )
 
 type telegramNotifier struct {
-	transport *telegram.Telegram // wrapper around telegram bot API that suits my needs
+	bot       *tgbotapi.BotAPI
+	token     string
+	chatID    int64
+	options   config.TelegramOptions
+	parseMode string
+	template  *render.Template
+	messages  *messageStore // live messages, nil if live progress is disabled
 }
 
 func NewTelegramNotifier(
 	token string,
+	apiURL string,
 	chatID int64,
+	options config.TelegramOptions,
+	stateDir string,
+	template *render.Template,
 ) (notify.Notifier, error) {
 
-	token = strings.TrimSpace(token)
-	tgTransport, err := telegram.New(token)
+	bot, err := newBotAPI(token, apiURL)
 	if err != nil {
 		return nil, err
 	}
-	tgTransport.SetParseMode(telegram.ModeMarkdown)
-	tgTransport.AddReceivers(chatID)
 
-	return &telegramNotifier{
-		transport: tgTransport,
-	}, nil
+	tgn := &telegramNotifier{
+		bot:       bot,
+		token:     bot.Token,
+		chatID:    chatID,
+		options:   options,
+		parseMode: parseMode(template.Format()),
+		template:  template,
+	}
+	if options.LiveProgress {
+		if tgn.messages, err = newMessageStore(stateDir); err != nil {
+			return nil, err
+		}
+	}
+	return tgn, nil
+}
+
+func parseMode(format render.Format) string {
+	switch format {
+	case render.FormatMarkdown:
+		return tgbotapi.ModeMarkdown
+	case render.FormatMarkdownV2:
+		return "MarkdownV2"
+	case render.FormatHTML:
+		return tgbotapi.ModeHTML
+	default:
+		return ""
+	}
 }
 
 func (tgn *telegramNotifier) Notify(ctx context.Context, data *types.NotificationData) error {
+	text, parseMode, err := tgn.render(data)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if tgn.messages == nil || data.Invocation == nil {
+		_, err := tgn.send(text, parseMode)
+		return err
+	}
+
+	// live message: the first in-progress notification sends it, the next ones and the result edit it
+	id := data.Invocation.InvocationID
+	final := data.Kind != types.NotificationInProgress
+	if msgID, ok := tgn.messages.get(id); ok {
+		err := tgn.edit(msgID, text, parseMode)
+		if err == nil || isNotModified(err) {
+			if final {
+				tgn.messages.remove(id)
+			}
+			return nil
+		}
+		fmt.Printf("telegram: failed to edit live message of invocation %s, sending the new one: %v\n", id, err)
+	}
+
+	msgID, err := tgn.send(text, parseMode)
+	if err != nil {
+		return err
+	}
+	if final {
+		tgn.messages.remove(id)
+		return nil
+	}
+	return tgn.messages.put(id, msgID)
+}
+
+// render fits the message into the telegram limit cutting the output tail first, markup is dropped if it does not help
+func (tgn *telegramNotifier) render(data *types.NotificationData) (string, string, error) {
+	text, err := tgn.template.Render(data)
+	if err != nil {
+		return "", "", err
+	}
+	overflow := utf8.RuneCountInString(text) - maxMessageLen
+	if overflow <= 0 {
+		return text, tgn.parseMode, nil
+	}
 
-	mdStr := fmt.Sprintf(`
-Command *%s* has finished its execution
-- machine: *%s*
-- invocation-id: *%s*
-- execution time: *%d sec*
-`,
-		data.Invocation.ShellLine,
-		data.Invocation.MachineID,
-		data.Invocation.InvocationID,
-		data.ExecTime,
-	)
-
-	err := tgn.transport.Send(ctx, "shnotify update", mdStr)
-	return err
+	if tail := []rune(data.OutputTail); len(tail) != 0 {
+		shortened := *data
+		keep := max(len(tail)-overflow-len(truncatedMark), 0)
+		shortened.OutputTail = truncatedMark + string(tail[len(tail)-keep:])
+		if text, err = tgn.template.Render(&shortened); err != nil {
+			return "", "", err
+		}
+		if utf8.RuneCountInString(text) <= maxMessageLen {
+			return text, tgn.parseMode, nil
+		}
+	}
+
+	// cut markup cannot be parsed by telegram
+	return render.Truncate(maxMessageLen, text), "", nil
+}
+
+const truncatedMark = "...\n"
+
+// send posts the message, the library does not support topics so the request is made directly
+func (tgn *telegramNotifier) send(text, parseMode string) (int, error) {
+	params := tgn.params(text, parseMode)
+	params.Set("disable_notification", strconv.FormatBool(tgn.options.Silent))
+	if tgn.options.ThreadID != 0 {
+		params.Set("message_thread_id", strconv.Itoa(tgn.options.ThreadID))
+	}
+
+	resp, err := tgn.bot.MakeRequest("sendMessage", params)
+	if err != nil {
+		return 0, fmt.Errorf("send message to chat %d: %w", tgn.chatID, scrub(err, tgn.token))
+	}
+	var msg tgbotapi.Message
+	if err := json.Unmarshal(resp.Result, &msg); err != nil {
+		return 0, fmt.Errorf("send message to chat %d: %w", tgn.chatID, err)
+	}
+	return msg.MessageID, nil
+}
+
+func (tgn *telegramNotifier) edit(msgID int, text, parseMode string) error {
+	params := tgn.params(text, parseMode)
+	params.Set("message_id", strconv.Itoa(msgID))
+
+	if _, err := tgn.bot.MakeRequest("editMessageText", params); err != nil {
+		return fmt.Errorf("edit message %d in chat %d: %w", msgID, tgn.chatID, scrub(err, tgn.token))
+	}
+	return nil
+}
+
+func (tgn *telegramNotifier) params(text, parseMode string) url.Values {
+	params := url.Values{}
+	params.Set("chat_id", strconv.FormatInt(tgn.chatID, 10))
+	params.Set("text", text)
+	if len(parseMode) != 0 {
+		params.Set("parse_mode", parseMode)
+	}
+	return params
+}
+
+// isNotModified reports the edit with the same text (e.g. in-progress notification is retried)
+func isNotModified(err error) bool {
+	return strings.Contains(err.Error(), "message is not modified")
+}
+
+// scrub removes the token from the error, http errors of the bot api contain the request url with it
+func scrub(err error, token string) error {
+	if len(token) == 0 || !strings.Contains(err.Error(), token) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), token, "***"))
 }
@@ -0,0 +1,501 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/types"
+)
+
+const (
+	defaultWorkers        = 2
+	defaultMaxAttempts    = 8
+	defaultInitialBackoff = 5 * time.Second
+	defaultMaxBackoff     = 30 * time.Minute
+
+	pollInterval = time.Second
+)
+
+var ErrJobNotFound = errors.New("outbox job not found")
+
+// DeliverFunc sends the notification of the job, outbox retries it if error is returned
+type DeliverFunc func(ctx context.Context, job *types.OutboxJob) error
+
+// Outbox persists notifications and delivers them with retries.
+// Jobs are stored as files, pending ones are removed only after successful delivery
+// so nothing is lost if the daemon is stopped in the middle.
+type Outbox struct {
+	config  config.OutboxConfig
+	clock   common.Clock
+	deliver DeliverFunc
+
+	pendingDir string
+	deadDir    string
+
+	mu       sync.Mutex
+	inflight map[string]string   // job id to the invocation id, empty for the digests
+	busy     map[string]struct{} // invocations having a job in flight
+	wakeup   chan struct{}
+
+	// deliveries outlive the context of Run to be finished by Drain, abort cancels them
+	deliverCtx context.Context
+	abort      context.CancelFunc
+	running    sync.WaitGroup
+	delivered  atomic.Int64
+}
+
+func New(
+	cfg config.OutboxConfig,
+	clock common.Clock,
+	deliver DeliverFunc,
+) (*Outbox, error) {
+
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = config.Duration(defaultInitialBackoff)
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = config.Duration(defaultMaxBackoff)
+	}
+
+	ob := &Outbox{
+		config:     cfg,
+		clock:      clock,
+		deliver:    deliver,
+		pendingDir: path.Join(cfg.DirPath, "pending"),
+		deadDir:    path.Join(cfg.DirPath, "dead"),
+		inflight:   make(map[string]string),
+		busy:       make(map[string]struct{}),
+		wakeup:     make(chan struct{}, 1),
+	}
+	ob.deliverCtx, ob.abort = context.WithCancel(context.Background())
+
+	for _, dir := range []string{ob.pendingDir, ob.deadDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); common.IgnoreErr(err, os.ErrExist) != nil {
+			return nil, err
+		}
+	}
+
+	return ob, nil
+}
+
+func (ob *Outbox) Enqueue(notifier string, data *types.NotificationData) error {
+	return ob.enqueue(notifier, data, ob.clock.NowUnix(), false)
+}
+
+// Defer postpones the job until the given unix timestamp, the delivery function gets it marked as deferred
+func (ob *Outbox) Defer(notifier string, data *types.NotificationData, at int64) error {
+	return ob.enqueue(notifier, data, at, true)
+}
+
+func (ob *Outbox) enqueue(notifier string, data *types.NotificationData, at int64, deferred bool) error {
+	job := &types.OutboxJob{
+		ID:            uuid.NewString(),
+		Notifier:      notifier,
+		Data:          data,
+		CreatedAt:     ob.clock.NowUnix(),
+		CreatedAtMs:   ob.clock.NowUnixMilli(),
+		NextAttemptAt: at,
+		Deferred:      deferred,
+	}
+	if err := ob.save(ob.pendingDir, job); err != nil {
+		return err
+	}
+	ob.notifyWorkers()
+	return nil
+}
+
+// Run delivers the jobs until the context is cancelled, the deliveries in flight are awaited before the return
+func (ob *Outbox) Run(ctx context.Context) error {
+	ob.running.Add(1)
+	defer ob.running.Done()
+
+	jobs := make(chan *types.OutboxJob)
+
+	var wg sync.WaitGroup
+	for range ob.config.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				ob.process(ob.deliverCtx, job)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		due, err := ob.dueJobs()
+		if err != nil {
+			fmt.Printf("failed to read outbox: %v\n", err)
+		}
+		for i, job := range due {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				// not dispatched jobs are left to Drain
+				for _, rest := range due[i:] {
+					ob.release(rest.ID)
+				}
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-ob.wakeup:
+		}
+	}
+}
+
+// Drain waits for Run to be stopped and sends the jobs which are due, it is called on the shutdown.
+// Deliveries are cancelled when the context is done, the jobs left pending are delivered after the restart.
+func (ob *Outbox) Drain(ctx context.Context) (delivered, left int) {
+	defer context.AfterFunc(ctx, ob.abort)()
+	start := ob.delivered.Load()
+
+	stopped := make(chan struct{})
+	go func() {
+		ob.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+
+	for ctx.Err() == nil {
+		due, err := ob.dueJobs()
+		if err != nil {
+			fmt.Printf("failed to read outbox: %v\n", err)
+			break
+		}
+		if len(due) == 0 {
+			break
+		}
+		for _, job := range due {
+			if ctx.Err() != nil {
+				ob.release(job.ID)
+				continue
+			}
+			// notifiers may ignore the cancellation, the job stays pending if it is not finished in time
+			processed := make(chan struct{})
+			go func() {
+				defer close(processed)
+				ob.process(ob.deliverCtx, job)
+			}()
+			select {
+			case <-processed:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	left, err := ob.Depth()
+	if err != nil {
+		fmt.Printf("failed to read outbox: %v\n", err)
+	}
+	return int(ob.delivered.Load() - start), left
+}
+
+// dueJobs returns pending jobs ready for the next attempt and marks them in flight.
+// The jobs of an invocation are delivered one at a time in the order of creation,
+// e.g. the in-progress message is never posted concurrently with the final one
+func (ob *Outbox) dueJobs() ([]*types.OutboxJob, error) {
+	pending, err := ob.load(ob.pendingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ob.clock.NowUnix()
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	due := make([]*types.OutboxJob, 0, len(pending))
+	for _, job := range pending {
+		if _, inflight := ob.inflight[job.ID]; inflight || job.NextAttemptAt > now {
+			continue
+		}
+		key := invocationKey(job)
+		if len(key) != 0 {
+			if _, busy := ob.busy[key]; busy {
+				continue
+			}
+			ob.busy[key] = struct{}{}
+		}
+		ob.inflight[job.ID] = key
+		due = append(due, job)
+	}
+	return due, nil
+}
+
+// invocationKey returns the invocation the job notifies of, the digests are not ordered with the other jobs
+func invocationKey(job *types.OutboxJob) string {
+	if job.Data == nil || job.Data.Invocation == nil || job.Data.Kind == types.NotificationDigest {
+		return ""
+	}
+	return string(job.Data.Invocation.InvocationID)
+}
+
+func (ob *Outbox) process(ctx context.Context, job *types.OutboxJob) {
+	defer ob.release(job.ID)
+
+	err := ob.deliver(ctx, job)
+	if err == nil {
+		ob.delivered.Add(1)
+		if err := ob.remove(ob.pendingDir, job.ID); err != nil {
+			fmt.Printf("failed to remove delivered outbox job %s: %v\n", job.ID, err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// shutting down, the job stays pending and is delivered after the restart
+		return
+	}
+
+	if _, err := os.Stat(path.Join(ob.pendingDir, job.ID+".json")); err != nil {
+		// dropped while being delivered
+		return
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+
+	if job.Attempts >= ob.config.MaxAttempts {
+		job.Dead = true
+		fmt.Printf("notification %s via %s failed %d times, moving to dead letters: %v\n", job.ID, job.Notifier, job.Attempts, err)
+		if err := ob.move(job, ob.deadDir); err != nil {
+			fmt.Printf("failed to move outbox job %s to dead letters: %v\n", job.ID, err)
+		}
+		return
+	}
+
+	backoff := ob.backoff(job.Attempts)
+	job.NextAttemptAt = ob.clock.NowUnix() + int64(backoff.Seconds())
+	fmt.Printf("notification %s via %s failed, will retry in %s: %v\n", job.ID, job.Notifier, backoff, err)
+	if err := ob.save(ob.pendingDir, job); err != nil {
+		fmt.Printf("failed to update outbox job %s: %v\n", job.ID, err)
+	}
+}
+
+// backoff is exponential with the 'equal jitter': half of the delay is randomized
+func (ob *Outbox) backoff(attempts int) time.Duration {
+	delay := time.Duration(ob.config.InitialBackoff)
+	for i := 1; i < attempts && delay < time.Duration(ob.config.MaxBackoff); i++ {
+		delay *= 2
+	}
+	delay = min(delay, time.Duration(ob.config.MaxBackoff))
+	return max(delay/2+rand.N(delay/2+1), time.Second)
+}
+
+func (ob *Outbox) release(id string) {
+	ob.mu.Lock()
+	key, ok := ob.inflight[id]
+	delete(ob.inflight, id)
+	if len(key) != 0 {
+		delete(ob.busy, key)
+	}
+	ob.mu.Unlock()
+
+	if ok && len(key) != 0 {
+		// the next job of the invocation may be waiting
+		ob.notifyWorkers()
+	}
+}
+
+func (ob *Outbox) notifyWorkers() {
+	select {
+	case ob.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// List returns pending jobs followed by the dead letters
+func (ob *Outbox) List() ([]types.OutboxJob, error) {
+	pending, err := ob.load(ob.pendingDir)
+	if err != nil {
+		return nil, err
+	}
+	dead, err := ob.load(ob.deadDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]types.OutboxJob, 0, len(pending)+len(dead))
+	for _, job := range append(pending, dead...) {
+		ret = append(ret, *job)
+	}
+	return ret, nil
+}
+
+// Depth returns the number of jobs awaiting delivery
+func (ob *Outbox) Depth() (int, error) {
+	pending, err := ob.load(ob.pendingDir)
+	return len(pending), err
+}
+
+// Retry schedules the job for immediate delivery, dead letters are revived with reset attempts
+func (ob *Outbox) Retry(id string) error {
+	job, dead, err := ob.find(id)
+	if err != nil {
+		return err
+	}
+
+	job.NextAttemptAt = ob.clock.NowUnix()
+	if dead {
+		job.Dead = false
+		job.Attempts = 0
+		err = ob.move(job, ob.pendingDir)
+	} else {
+		err = ob.save(ob.pendingDir, job)
+	}
+	if err != nil {
+		return err
+	}
+	ob.notifyWorkers()
+	return nil
+}
+
+// RetryDead revives all the dead letters and returns their number
+func (ob *Outbox) RetryDead() (int, error) {
+	dead, err := ob.load(ob.deadDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range dead {
+		if err := ob.Retry(job.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(dead), nil
+}
+
+func (ob *Outbox) Drop(id string) error {
+	_, dead, err := ob.find(id)
+	if err != nil {
+		return err
+	}
+	if dead {
+		return ob.remove(ob.deadDir, id)
+	}
+	return ob.remove(ob.pendingDir, id)
+}
+
+// DropDead removes all the dead letters and returns their number
+func (ob *Outbox) DropDead() (int, error) {
+	dead, err := ob.load(ob.deadDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range dead {
+		if err := ob.remove(ob.deadDir, job.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(dead), nil
+}
+
+func (ob *Outbox) find(id string) (*types.OutboxJob, bool, error) {
+	if job, err := ob.read(path.Join(ob.pendingDir, id+".json")); err == nil {
+		return job, false, nil
+	}
+	if job, err := ob.read(path.Join(ob.deadDir, id+".json")); err == nil {
+		return job, true, nil
+	}
+	return nil, false, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+}
+
+func (ob *Outbox) save(dir string, job *types.OutboxJob) error {
+	marshaled, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	// write + rename to never expose partially written jobs to the workers
+	tmpName := path.Join(dir, "."+job.ID+".json")
+	if err := os.WriteFile(tmpName, marshaled, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path.Join(dir, job.ID+".json"))
+}
+
+func (ob *Outbox) move(job *types.OutboxJob, dir string) error {
+	from := ob.pendingDir
+	if dir == ob.pendingDir {
+		from = ob.deadDir
+	}
+	if err := ob.save(dir, job); err != nil {
+		return err
+	}
+	return ob.remove(from, job.ID)
+}
+
+func (ob *Outbox) remove(dir, id string) error {
+	err := os.Remove(path.Join(dir, id+".json"))
+	return common.IgnoreErr(err, os.ErrNotExist)
+}
+
+func (ob *Outbox) read(filePath string) (*types.OutboxJob, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var job types.OutboxJob
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// load returns the jobs of the directory ordered by creation time
+func (ob *Outbox) load(dir string) ([]*types.OutboxJob, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*types.OutboxJob, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		job, err := ob.read(path.Join(dir, name))
+		if err != nil {
+			// removed concurrently
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	slices.SortFunc(jobs, func(lhs, rhs *types.OutboxJob) int {
+		return cmp.Or(cmp.Compare(lhs.CreatedAt, rhs.CreatedAt), cmp.Compare(lhs.CreatedAtMs, rhs.CreatedAtMs))
+	})
+	return jobs, nil
+}
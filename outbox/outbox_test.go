@@ -0,0 +1,235 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/oclaw/shnotify/common/clocktest"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/types"
+)
+
+func newTestOutbox(t *testing.T, cfg config.OutboxConfig, clock *clocktest.Clock, deliver DeliverFunc) *Outbox {
+	t.Helper()
+	cfg.DirPath = t.TempDir()
+	ob, err := New(cfg, clock, deliver)
+	if err != nil {
+		t.Fatalf("failed to create outbox: %v", err)
+	}
+	return ob
+}
+
+// runDue delivers the jobs which are due as the workers do
+func runDue(t *testing.T, ob *Outbox) int {
+	t.Helper()
+	due, err := ob.dueJobs()
+	if err != nil {
+		t.Fatalf("failed to get due jobs: %v", err)
+	}
+	for _, job := range due {
+		ob.process(context.Background(), job)
+	}
+	return len(due)
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  time.Duration
+		max      time.Duration
+		attempts int
+		from, to time.Duration
+	}{
+		{"first failure", 4 * time.Second, time.Minute, 1, 2 * time.Second, 4 * time.Second},
+		{"doubled", 4 * time.Second, time.Minute, 2, 4 * time.Second, 8 * time.Second},
+		{"doubled twice", 4 * time.Second, time.Minute, 3, 8 * time.Second, 16 * time.Second},
+		{"capped", 4 * time.Second, time.Minute, 5, 30 * time.Second, time.Minute},
+		{"capped long after", 4 * time.Second, time.Minute, 40, 30 * time.Second, time.Minute},
+		{"at least a second", time.Second, time.Minute, 1, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := newTestOutbox(t, config.OutboxConfig{
+				InitialBackoff: config.Duration(tt.initial),
+				MaxBackoff:     config.Duration(tt.max),
+			}, clocktest.New(0), nil)
+
+			// half of the delay is random
+			for range 100 {
+				got := ob.backoff(tt.attempts)
+				if got < tt.from || got > tt.to {
+					t.Fatalf("backoff(%d) = %s, expected within [%s, %s]", tt.attempts, got, tt.from, tt.to)
+				}
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errDelivery := errors.New("service is down")
+
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		failures     int // number of the first deliveries failing
+		rounds       int
+		wantCalls    int
+		wantPending  int
+		wantDead     int
+		wantAttempts int // of the job left in the outbox
+	}{
+		{"delivered at once", 3, 0, 3, 1, 0, 0, 0},
+		{"delivered after retries", 3, 2, 3, 3, 0, 0, 0},
+		{"retry pending", 3, 5, 2, 2, 1, 0, 2},
+		{"moved to dead letters", 3, 5, 5, 3, 0, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.New(1000)
+			calls := 0
+			ob := newTestOutbox(t, config.OutboxConfig{
+				MaxAttempts:    tt.maxAttempts,
+				InitialBackoff: config.Duration(10 * time.Second),
+				MaxBackoff:     config.Duration(time.Minute),
+			}, clock, func(ctx context.Context, job *types.OutboxJob) error {
+				calls++
+				if calls <= tt.failures {
+					return errDelivery
+				}
+				return nil
+			})
+
+			if err := ob.Enqueue("tg", &types.NotificationData{Kind: types.NotificationFinished}); err != nil {
+				t.Fatalf("failed to enqueue: %v", err)
+			}
+			for range tt.rounds {
+				before := calls
+				runDue(t, ob)
+				if calls != before && runDue(t, ob) != 0 {
+					t.Fatalf("failed job is due again before the backoff")
+				}
+				clock.Advance(time.Minute)
+			}
+
+			if calls != tt.wantCalls {
+				t.Errorf("delivered %d times, expected %d", calls, tt.wantCalls)
+			}
+			jobs, err := ob.List()
+			if err != nil {
+				t.Fatalf("failed to list: %v", err)
+			}
+			pending, dead := 0, 0
+			for _, job := range jobs {
+				if job.Dead {
+					dead++
+				} else {
+					pending++
+				}
+				if job.Attempts != tt.wantAttempts {
+					t.Errorf("job has %d attempts, expected %d", job.Attempts, tt.wantAttempts)
+				}
+				if job.LastError != errDelivery.Error() {
+					t.Errorf("job has last error '%s', expected '%s'", job.LastError, errDelivery)
+				}
+			}
+			if pending != tt.wantPending || dead != tt.wantDead {
+				t.Errorf("%d pending and %d dead jobs, expected %d and %d", pending, dead, tt.wantPending, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	clock := clocktest.New(1000)
+	failing := true
+	ob := newTestOutbox(t, config.OutboxConfig{MaxAttempts: 1}, clock, func(ctx context.Context, job *types.OutboxJob) error {
+		if failing {
+			return errors.New("service is down")
+		}
+		return nil
+	})
+
+	if err := ob.Enqueue("tg", &types.NotificationData{}); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+	runDue(t, ob)
+	revived, err := ob.RetryDead()
+	if err != nil || revived != 1 {
+		t.Fatalf("RetryDead() = %d, %v, expected 1 job revived", revived, err)
+	}
+
+	jobs, err := ob.List()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("List() = %v, %v, expected single job", jobs, err)
+	}
+	if job := jobs[0]; job.Dead || job.Attempts != 0 || job.NextAttemptAt != clock.NowUnix() {
+		t.Errorf("revived job %+v is expected to be pending with reset attempts and due now", job)
+	}
+
+	failing = false
+	if runDue(t, ob) != 1 {
+		t.Fatalf("revived job is not due")
+	}
+	if depth, _ := ob.Depth(); depth != 0 {
+		t.Errorf("%d jobs left after delivery", depth)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	clock := clocktest.New(1000)
+	var delivered []*types.OutboxJob
+	ob := newTestOutbox(t, config.OutboxConfig{}, clock, func(ctx context.Context, job *types.OutboxJob) error {
+		delivered = append(delivered, job)
+		return nil
+	})
+
+	if err := ob.Defer("tg", &types.NotificationData{}, 1100); err != nil {
+		t.Fatalf("failed to defer: %v", err)
+	}
+	if runDue(t, ob) != 0 {
+		t.Fatalf("deferred job is delivered before its time")
+	}
+	clock.Set(1100)
+	if runDue(t, ob) != 1 {
+		t.Fatalf("deferred job is not delivered in time")
+	}
+	if !delivered[0].Deferred {
+		t.Errorf("delivered job is not marked as deferred")
+	}
+}
+
+func TestInvocationOrder(t *testing.T) {
+	clock := clocktest.New(1000)
+	var delivered []string
+	ob := newTestOutbox(t, config.OutboxConfig{}, clock, func(ctx context.Context, job *types.OutboxJob) error {
+		delivered = append(delivered, string(job.Data.Invocation.InvocationID)+":"+string(job.Data.Kind))
+		return nil
+	})
+
+	enqueue := func(id types.InvocationID, kind types.NotificationKind) {
+		t.Helper()
+		data := &types.NotificationData{Kind: kind, Invocation: &types.ShellInvocationRecord{InvocationID: id}}
+		if err := ob.Enqueue("tg", data); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+		clock.Advance(time.Millisecond) // ordered within the second
+	}
+	enqueue("a", types.NotificationInProgress)
+	enqueue("a", types.NotificationFinished)
+	enqueue("b", types.NotificationFinished)
+
+	// the finished job of the invocation waits for its in-progress one
+	if n := runDue(t, ob); n != 2 {
+		t.Fatalf("%d jobs are delivered at once, expected 2", n)
+	}
+	if n := runDue(t, ob); n != 1 {
+		t.Fatalf("%d jobs are delivered after the first ones, expected 1", n)
+	}
+	want := []string{"a:in_progress", "b:finished", "a:finished"}
+	if !slices.Equal(delivered, want) {
+		t.Errorf("delivered %v, expected %v", delivered, want)
+	}
+}
@@ -0,0 +1,50 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const mask = "***"
+
+type rule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// builtin rules for the secrets that usually leak into the command lines and outputs
+var builtinRules = []rule{
+	{regexp.MustCompile(`(?i)((?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)["']?\s*[=:]\s*["']?)[^\s"']+`), "${1}" + mask},
+	{regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9._~+/-]+=*`), "${1}" + mask},
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}" + mask + "@"}, // credentials in urls
+	{regexp.MustCompile(`AKIA[0-9A-Z]{16}`), mask},
+	{regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36,}`), mask},
+	{regexp.MustCompile(`\b\d{8,10}:[A-Za-z0-9_-]{35}\b`), mask}, // telegram bot token
+}
+
+// Redactor masks secrets in the text that is going to leave the machine
+type Redactor struct {
+	rules []rule
+}
+
+// New creates redactor with the builtin rules and user defined patterns (whole match of the pattern is masked)
+func New(extraPatterns []string) (*Redactor, error) {
+	rd := &Redactor{
+		rules: builtinRules,
+	}
+	for _, raw := range extraPatterns {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern '%s': %w", raw, err)
+		}
+		rd.rules = append(rd.rules[:len(rd.rules):len(rd.rules)], rule{re, mask})
+	}
+	return rd, nil
+}
+
+func (rd *Redactor) Redact(text string) string {
+	for _, r := range rd.rules {
+		text = r.re.ReplaceAllString(text, r.replacement)
+	}
+	return text
+}
@@ -14,21 +14,33 @@ import (
 )
 
 type Client struct {
-	http *http.Client
+	http  *http.Client
+	token string
 }
 
 func NewClient(path string) (*Client, error) {
+	return NewNetworkClient("unix", path, "")
+}
+
+// NewNetworkClient creates a client talking to the server over the given network ("unix" or "tcp"),
+// the token is presented to the tcp listener of the hub daemon
+func NewNetworkClient(network, address, token string) (*Client, error) {
+	switch network {
+	case "unix", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported rpc network '%s'", network)
+	}
 	httpClient := &http.Client{
 		Transport: &http.Transport{
-			// TODO adapt for any kind of sockets
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
 				var d net.Dialer
-				return d.DialContext(ctx, "unix", path)
+				return d.DialContext(ctx, network, address)
 			},
 		},
 	}
 	return &Client{
-		http: httpClient,
+		http:  httpClient,
+		token: token,
 	}, nil
 }
 
@@ -48,13 +60,11 @@ func (cl *Client) SaveInvocation(ctx context.Context, req *types.InvocationReque
 	return res.InvocationID, nil
 }
 
-func (cl *Client) Notify(ctx context.Context, id types.InvocationID) error {
+func (cl *Client) Notify(ctx context.Context, req *types.NotifyRequest) error {
 	_, err := callHTTP[rpctypes.NotifyRequest, any](
 		ctx,
 		cl,
-		&rpctypes.NotifyRequest{
-			InvocationID: id,
-		},
+		(*rpctypes.NotifyRequest)(req),
 		requestContext{
 			method: http.MethodPost,
 			path:   "notify",
@@ -66,6 +76,143 @@ func (cl *Client) Notify(ctx context.Context, id types.InvocationID) error {
 	return nil
 }
 
+func (cl *Client) ListInvocations(ctx context.Context) ([]types.RunningInvocation, error) {
+	res, err := callHTTP[rpctypes.ListInvocationsRequest, rpctypes.ListInvocationsResponse](
+		ctx,
+		cl,
+		&rpctypes.ListInvocationsRequest{},
+		requestContext{
+			method: http.MethodPost,
+			path:   "list-invocations",
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return res.Invocations, nil
+}
+
+func (cl *Client) ListOutbox(ctx context.Context) ([]types.OutboxJob, error) {
+	res, err := callHTTP[rpctypes.OutboxListRequest, rpctypes.OutboxListResponse](
+		ctx,
+		cl,
+		&rpctypes.OutboxListRequest{},
+		requestContext{
+			method: http.MethodPost,
+			path:   "outbox/list",
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return res.Jobs, nil
+}
+
+func (cl *Client) RetryOutbox(ctx context.Context, jobID string) (int, error) {
+	return cl.outboxAction(ctx, "outbox/retry", jobID)
+}
+
+func (cl *Client) DropOutbox(ctx context.Context, jobID string) (int, error) {
+	return cl.outboxAction(ctx, "outbox/drop", jobID)
+}
+
+func (cl *Client) outboxAction(ctx context.Context, path, jobID string) (int, error) {
+	res, err := callHTTP[rpctypes.OutboxActionRequest, rpctypes.OutboxActionResponse](
+		ctx,
+		cl,
+		&rpctypes.OutboxActionRequest{
+			JobID: jobID,
+		},
+		requestContext{
+			method: http.MethodPost,
+			path:   path,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.Affected, nil
+}
+
+// Reload asks the daemon to re-read its config
+func (cl *Client) Reload(ctx context.Context) error {
+	_, err := callHTTP[rpctypes.ReloadRequest, rpctypes.ReloadResponse](
+		ctx,
+		cl,
+		&rpctypes.ReloadRequest{},
+		requestContext{
+			method: http.MethodPost,
+			path:   "reload",
+		},
+	)
+	return err
+}
+
+// Status returns the state of the running daemon
+func (cl *Client) Status(ctx context.Context) (*types.DaemonStatus, error) {
+	return callHTTP[rpctypes.StatusRequest, rpctypes.StatusResponse](
+		ctx,
+		cl,
+		&rpctypes.StatusRequest{},
+		requestContext{
+			method: http.MethodPost,
+			path:   "status",
+		},
+	)
+}
+
+// Shutdown asks the daemon to stop, it returns before the daemon finishes its shutdown
+func (cl *Client) Shutdown(ctx context.Context) error {
+	_, err := callHTTP[rpctypes.ShutdownRequest, rpctypes.ShutdownResponse](
+		ctx,
+		cl,
+		&rpctypes.ShutdownRequest{},
+		requestContext{
+			method: http.MethodPost,
+			path:   "shutdown",
+		},
+	)
+	return err
+}
+
+// Watch streams tracker events to the callback until the context is cancelled or the callback fails
+func (cl *Client) Watch(ctx context.Context, onEvent func(*types.Event) error) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteURL("events").String(), nil)
+	if err != nil {
+		return err
+	}
+	cl.authorize(httpReq)
+
+	httpRes, err := cl.http.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = httpRes.Body.Close()
+	}()
+
+	if httpRes.StatusCode == http.StatusUnauthorized {
+		return rpctypes.ErrUnauthorized
+	}
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected RPC response: %v", httpRes.Status)
+	}
+
+	decoder := json.NewDecoder(httpRes.Body)
+	for {
+		var ev types.Event
+		if err := decoder.Decode(&ev); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err := onEvent(&ev); err != nil {
+			return err
+		}
+	}
+}
+
 type requestContext struct {
 	method string
 	path   string
@@ -77,15 +224,11 @@ func callHTTP[Req, Res any](ctx context.Context, cl *Client, req *Req, reqCtx re
 		return nil, err
 	}
 
-	var remoteURL url.URL
-	remoteURL.Host = "localhost"
-	remoteURL.Path = reqCtx.path
-	remoteURL.Scheme = "http"
-
-	httpReq, err := http.NewRequestWithContext(ctx, reqCtx.method, remoteURL.String(), bytes.NewReader(payload))
+	httpReq, err := http.NewRequestWithContext(ctx, reqCtx.method, remoteURL(reqCtx.path).String(), bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
+	cl.authorize(httpReq)
 
 	httpRes, err := cl.http.Do(httpReq)
 	if err != nil {
@@ -95,6 +238,9 @@ func callHTTP[Req, Res any](ctx context.Context, cl *Client, req *Req, reqCtx re
 		_ = httpRes.Body.Close()
 	}()
 
+	if httpRes.StatusCode == http.StatusUnauthorized {
+		return nil, rpctypes.ErrUnauthorized
+	}
 	if httpRes.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Unexpected RPC response: %v", httpRes.Status)
 	}
@@ -107,7 +253,22 @@ func callHTTP[Req, Res any](ctx context.Context, cl *Client, req *Req, reqCtx re
 	return rpcResponse.Unwrap()
 }
 
+func (cl *Client) authorize(req *http.Request) {
+	if len(cl.token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+cl.token)
+	}
+}
+
+func remoteURL(path string) *url.URL {
+	var ret url.URL
+	ret.Host = "localhost"
+	ret.Path = path
+	ret.Scheme = "http"
+	return &ret
+}
+
 type InvocationTracker interface {
 	SaveInvocation(ctx context.Context, req *types.InvocationRequest) (types.InvocationID, error)
-	Notify(ctx context.Context, id types.InvocationID) error
+	Notify(ctx context.Context, req *types.NotifyRequest) error
+	ListInvocations(ctx context.Context) ([]types.RunningInvocation, error)
 }
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/oclaw/shnotify/core"
+	rpctypes "github.com/oclaw/shnotify/rpc/types"
+)
+
+func (s *Server) outbox() (core.OutboxManager, error) {
+	manager, ok := s.impl.(core.OutboxManager)
+	if !ok {
+		return nil, fmt.Errorf("outbox is not supported by the daemon")
+	}
+	return manager, nil
+}
+
+func (s *Server) handleOutbox(mux *http.ServeMux) {
+	handle(mux, "/outbox/list",
+		func(ctx context.Context, req *rpctypes.OutboxListRequest) (*rpctypes.OutboxListResponse, error) {
+			manager, err := s.outbox()
+			if err != nil {
+				return nil, err
+			}
+			jobs, err := manager.ListOutbox(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &rpctypes.OutboxListResponse{
+				Jobs: jobs,
+			}, nil
+		},
+	)
+
+	handle(mux, "/outbox/retry",
+		func(ctx context.Context, req *rpctypes.OutboxActionRequest) (*rpctypes.OutboxActionResponse, error) {
+			manager, err := s.outbox()
+			if err != nil {
+				return nil, err
+			}
+			affected, err := manager.RetryOutbox(ctx, req.JobID)
+			if err != nil {
+				return nil, err
+			}
+			return &rpctypes.OutboxActionResponse{
+				Affected: affected,
+			}, nil
+		},
+	)
+
+	handle(mux, "/outbox/drop",
+		func(ctx context.Context, req *rpctypes.OutboxActionRequest) (*rpctypes.OutboxActionResponse, error) {
+			manager, err := s.outbox()
+			if err != nil {
+				return nil, err
+			}
+			affected, err := manager.DropOutbox(ctx, req.JobID)
+			if err != nil {
+				return nil, err
+			}
+			return &rpctypes.OutboxActionResponse{
+				Affected: affected,
+			}, nil
+		},
+	)
+}
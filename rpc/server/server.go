@@ -2,109 +2,327 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
+	"github.com/oclaw/shnotify/common"
 	"github.com/oclaw/shnotify/config"
 	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/events"
 	rpctypes "github.com/oclaw/shnotify/rpc/types"
+	"github.com/oclaw/shnotify/secrets"
 )
 
+const eventsBufferSize = 64
+
 type Server struct {
-	impl   core.InvocationTracker
-	config *config.ShellTrackerConfig
+	impl      core.InvocationTracker
+	config    *config.ShellTrackerConfig
+	events    *events.Bus
+	reloader  core.Reloader
+	daemon    core.DaemonController
+	inherited []net.Listener
+
+	servers  []*http.Server
+	stopping chan struct{} // ends the event streams on shutdown, they never become idle
+	stopOnce sync.Once
 }
 
 func NewServer(
 	config *config.ShellTrackerConfig,
 	impl core.InvocationTracker,
+	bus *events.Bus,
+	reloader core.Reloader, // nil if the daemon does not support reload
+	daemon core.DaemonController,
+	inherited []net.Listener, // sockets passed by systemd, nil if the daemon is not socket activated
 ) (*Server, error) {
 
 	srv := &Server{
-		impl:   impl,
-		config: config,
+		impl:      impl,
+		config:    config,
+		events:    bus,
+		reloader:  reloader,
+		daemon:    daemon,
+		inherited: inherited,
+		stopping:  make(chan struct{}),
 	}
 
 	return srv, nil
 }
 
-func (s *Server) Serve(ctx context.Context) error {
-	if _, err := os.Stat(s.config.RPCSocketName); err == nil {
-		os.Remove(s.config.RPCSocketName)
+// Serve accepts the requests until the context is cancelled, ready is called once the sockets accept connections.
+// Shutdown has to be called then to finish the requests in flight.
+func (s *Server) Serve(ctx context.Context, ready func()) error {
+	token, err := s.remoteToken(ctx)
+	if err != nil {
+		return err
 	}
-
-	var listenCfg net.ListenConfig
-	listener, err := listenCfg.Listen(ctx, "unix", s.config.RPCSocketName)
+	unixListener, tcpListener, err := s.listen(ctx)
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
 
-	// TODO cleanup all this copypaste
-	http.HandleFunc("/save-invocation",
-		func(rw http.ResponseWriter, r *http.Request) {
-			var req rpctypes.SaveInvocationRequest
-			defer r.Body.Close()
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				rw.WriteHeader(http.StatusBadRequest)
-				return
+	mux := http.NewServeMux()
+	s.handleInvocations(mux)
+	s.handleOutbox(mux)
+
+	handle(mux, "/reload",
+		func(ctx context.Context, req *rpctypes.ReloadRequest) (*rpctypes.ReloadResponse, error) {
+			if s.reloader == nil {
+				return nil, fmt.Errorf("config reload is not supported by the daemon")
+			}
+			if err := s.reloader.Reload(ctx); err != nil {
+				return nil, err
 			}
-			id, err := s.impl.SaveInvocation(r.Context(), &req)
+			return &rpctypes.ReloadResponse{}, nil
+		},
+	)
+
+	handle(mux, "/status",
+		func(ctx context.Context, req *rpctypes.StatusRequest) (*rpctypes.StatusResponse, error) {
+			return s.daemon.Status(ctx)
+		},
+	)
+
+	handle(mux, "/shutdown",
+		func(ctx context.Context, req *rpctypes.ShutdownRequest) (*rpctypes.ShutdownResponse, error) {
+			// the response is sent before the server is stopped, it finishes the requests in flight
+			if err := s.daemon.Stop(ctx); err != nil {
+				return nil, err
+			}
+			return &rpctypes.ShutdownResponse{}, nil
+		},
+	)
+
+	mux.HandleFunc("/events", s.streamEvents)
+
+	done := make(chan error, 2)
+	s.serve(unixListener, mux, done)
+	if tcpListener != nil {
+		// the other daemons forward the invocations and list them, the management of the daemon is local
+		remoteMux := http.NewServeMux()
+		s.handleInvocations(remoteMux)
+		s.serve(tcpListener, requireToken(token, remoteMux), done)
+	}
+	ready()
+
+	select {
+	case err := <-done:
+		fmt.Printf("server finalized with error %v\n", err)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) serve(l net.Listener, handler http.Handler, done chan<- error) {
+	srv := &http.Server{Handler: handler}
+	srv.RegisterOnShutdown(s.stopStreams)
+	s.servers = append(s.servers, srv)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+}
+
+// handleInvocations registers the methods called by the shell hooks and the forwarding daemons,
+// the listing is read-only and answers `shnotify ps` on the forwarding machines
+func (s *Server) handleInvocations(mux *http.ServeMux) {
+	handle(mux, "/save-invocation",
+		func(ctx context.Context, req *rpctypes.SaveInvocationRequest) (*rpctypes.SaveInvocationResponse, error) {
+			id, err := s.impl.SaveInvocation(ctx, req)
 			if err != nil {
-				if err := writeErr(rw, err); err != nil {
-					rw.WriteHeader(http.StatusInternalServerError)
-				}
-				return
+				return nil, err
 			}
-			if err := writeOK(rw, rpctypes.SaveInvocationResponse{
+			return &rpctypes.SaveInvocationResponse{
 				InvocationID: id,
-			}); err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
+			}, nil
+		},
+	)
+
+	handle(mux, "/notify",
+		func(ctx context.Context, req *rpctypes.NotifyRequest) (*rpctypes.NotifyResponse, error) {
+			if err := s.impl.Notify(ctx, req); err != nil {
+				return nil, err
 			}
+			return &rpctypes.NotifyResponse{}, nil
 		},
 	)
 
-	http.HandleFunc("/notify",
+	handle(mux, "/list-invocations",
+		func(ctx context.Context, req *rpctypes.ListInvocationsRequest) (*rpctypes.ListInvocationsResponse, error) {
+			invocations, err := s.impl.ListInvocations(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &rpctypes.ListInvocationsResponse{
+				Invocations: invocations,
+			}, nil
+		},
+	)
+}
+
+// remoteToken resolves the token the forwarding daemons have to present on the tcp listener
+func (s *Server) remoteToken(ctx context.Context) (string, error) {
+	if len(s.config.RPCToken) == 0 {
+		return "", nil
+	}
+	token, err := secrets.Resolve(ctx, s.config.RPCToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve rpc_token: %w", err)
+	}
+	return token, nil
+}
+
+// requireToken rejects the requests without the bearer token, everything is accepted if the token is not set
+func requireToken(token string, next http.Handler) http.Handler {
+	if len(token) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Shutdown stops accepting the requests and waits for the ones in flight until the context is done,
+// the socket is removed unless it belongs to systemd
+func (s *Server) Shutdown(ctx context.Context) {
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Printf("rpc requests in flight are dropped: %v\n", err)
+			_ = srv.Close()
+		}
+	}
+
+	for _, l := range s.inherited {
+		if l.Addr().String() == s.config.RPCSocketName {
+			return
+		}
+	}
+	if err := os.Remove(s.config.RPCSocketName); common.IgnoreErr(err, os.ErrNotExist) != nil {
+		fmt.Printf("failed to remove socket %s: %v\n", s.config.RPCSocketName, err)
+	}
+}
+
+func (s *Server) stopStreams() {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
+}
+
+// listen takes over the sockets passed by systemd, the configured ones it has not passed are created
+func (s *Server) listen(ctx context.Context) (net.Listener, net.Listener, error) {
+	var unixListener, tcpListener net.Listener
+	for _, l := range s.inherited {
+		switch {
+		case l.Addr().Network() == "unix" && unixListener == nil:
+			if l.Addr().String() != s.config.RPCSocketName {
+				fmt.Printf("warning: socket %s passed by systemd differs from rpc_socket_name %s\n", l.Addr(), s.config.RPCSocketName)
+			}
+			unixListener = l
+		case l.Addr().Network() == "tcp" && tcpListener == nil && len(s.config.RPCListenTCP) != 0:
+			tcpListener = l
+		default:
+			fmt.Printf("ignoring socket %s passed by systemd\n", l.Addr())
+			_ = l.Close()
+		}
+	}
+
+	var listenCfg net.ListenConfig
+	if unixListener == nil {
+		if _, err := os.Stat(s.config.RPCSocketName); err == nil {
+			os.Remove(s.config.RPCSocketName)
+		}
+		listener, err := listenCfg.Listen(ctx, "unix", s.config.RPCSocketName)
+		if err != nil {
+			if tcpListener != nil {
+				_ = tcpListener.Close()
+			}
+			return nil, nil, err
+		}
+		unixListener = listener
+	}
+
+	if len(s.config.RPCListenTCP) != 0 && tcpListener == nil {
+		// accept invocations forwarded by other daemons
+		listener, err := listenCfg.Listen(ctx, "tcp", s.config.RPCListenTCP)
+		if err != nil {
+			_ = unixListener.Close()
+			return nil, nil, err
+		}
+		tcpListener = listener
+	}
+	if tcpListener != nil && len(s.config.RPCToken) == 0 && !config.LoopbackAddress(tcpListener.Addr().String()) {
+		_ = unixListener.Close()
+		_ = tcpListener.Close()
+		return nil, nil, fmt.Errorf("rpc_token has to be set to accept forwarded invocations on non-loopback address %s", tcpListener.Addr())
+	}
+	return unixListener, tcpListener, nil
+}
+
+// streamEvents writes tracker events as newline delimited json until the client disconnects
+func (s *Server) streamEvents(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok || s.events == nil {
+		rw.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	sub, release := s.events.Subscribe(eventsBufferSize)
+	defer release()
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(rw)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case ev := <-sub:
+			if err := encoder.Encode(&ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handle registers rpc method decoding the request and encoding the response of the implementation
+func handle[Req, Res any](mux *http.ServeMux, path string, impl func(context.Context, *Req) (*Res, error)) {
+	mux.HandleFunc(path,
 		func(rw http.ResponseWriter, r *http.Request) {
-			var req rpctypes.NotifyRequest
+			var req Req
 			defer r.Body.Close()
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				rw.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			err := s.impl.Notify(r.Context(), req.InvocationID)
+			res, err := impl(r.Context(), &req)
 			if err != nil {
 				if err := writeErr(rw, err); err != nil {
 					rw.WriteHeader(http.StatusInternalServerError)
 				}
 				return
 			}
-			if err := writeOK(rw, &rpctypes.NotifyResponse{}); err != nil {
+			if err := writeOK(rw, res); err != nil {
 				rw.WriteHeader(http.StatusInternalServerError)
 			}
 		},
 	)
-
-	done := make(chan error)
-	go func() {
-		err = http.Serve(listener, nil)
-		done <- err
-	}()
-
-	select {
-	case err, ok := <-done:
-		if ok && err != nil {
-			fmt.Printf("server finalized with error %v", err)
-			return err
-		}
-	case <-ctx.Done():
-		return ctx.Err()
-	}
-
-	panic("unreachable")
 }
 
 func writeOK[Response any](rw http.ResponseWriter, appRes Response) error {
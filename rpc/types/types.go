@@ -1,10 +1,18 @@
 package types
 
 import (
+	"errors"
 	"fmt"
+
 	"github.com/oclaw/shnotify/types"
 )
 
+// ErrRemote is returned when the request reached the server but was rejected by it
+var ErrRemote = errors.New("rpc error")
+
+// ErrUnauthorized is returned when the server does not accept the token of the client
+var ErrUnauthorized = errors.New("rpc token is rejected")
+
 type (
 	SaveInvocationRequest = types.InvocationRequest
 
@@ -12,13 +20,50 @@ type (
 		InvocationID types.InvocationID `json:"invocation_id"`
 	}
 
-	NotifyRequest struct {
-		InvocationID types.InvocationID `json:"invocation_id"`
-	}
+	NotifyRequest = types.NotifyRequest
 
 	NotifyResponse struct {
 	}
 
+	ListInvocationsRequest struct {
+	}
+
+	ListInvocationsResponse struct {
+		Invocations []types.RunningInvocation `json:"invocations"`
+	}
+
+	OutboxListRequest struct {
+	}
+
+	OutboxListResponse struct {
+		Jobs []types.OutboxJob `json:"jobs"`
+	}
+
+	OutboxActionRequest struct {
+		JobID string `json:"job_id,omitempty"` // all the dead letters if empty
+	}
+
+	OutboxActionResponse struct {
+		Affected int `json:"affected"`
+	}
+
+	ReloadRequest struct {
+	}
+
+	ReloadResponse struct {
+	}
+
+	StatusRequest struct {
+	}
+
+	StatusResponse = types.DaemonStatus
+
+	ShutdownRequest struct {
+	}
+
+	ShutdownResponse struct {
+	}
+
 	ErrResponse struct {
 		Code    int    `json:"code"`
 		Message string `json:"message"`
@@ -33,7 +78,7 @@ type (
 func (rsp *Response[Payload]) Unwrap() (*Payload, error) {
 	var emptyErr ErrResponse
 	if rsp.Error != emptyErr {
-		return nil, fmt.Errorf("rpc error: code=%d message='%s'", rsp.Error.Code, rsp.Error.Message)
+		return nil, fmt.Errorf("%w: code=%d message='%s'", ErrRemote, rsp.Error.Code, rsp.Error.Message)
 	}
 	return &rsp.Data, nil
 }
@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type dayRange struct {
+	days []time.Weekday // every day if empty
+	from int            // minutes since the midnight
+	to   int
+}
+
+func (r *dayRange) onDay(day time.Weekday) bool {
+	return len(r.days) == 0 || slices.Contains(r.days, day)
+}
+
+func (r *dayRange) active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if r.from <= r.to {
+		return r.onDay(t.Weekday()) && minute >= r.from && minute < r.to
+	}
+	// range wraps the midnight, its tail belongs to the previous day
+	return (r.onDay(t.Weekday()) && minute >= r.from) ||
+		(r.onDay((t.Weekday()+6)%7) && minute < r.to)
+}
+
+// Schedule tells whether the notifier is active at the moment
+type Schedule struct {
+	location *time.Location
+	ranges   []dayRange
+}
+
+func New(cfg *config.Schedule) (*Schedule, error) {
+	location := time.Local
+	if len(cfg.Timezone) != 0 {
+		var err error
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s': %w", cfg.Timezone, err)
+		}
+	}
+
+	sched := &Schedule{
+		location: location,
+	}
+	for _, raw := range cfg.Active {
+		var r dayRange
+		for _, day := range raw.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday '%s'", day)
+			}
+			r.days = append(r.days, weekday)
+		}
+		var err error
+		if r.from, err = parseClock(raw.From); err != nil {
+			return nil, err
+		}
+		if r.to, err = parseClock(raw.To); err != nil {
+			return nil, err
+		}
+		sched.ranges = append(sched.ranges, r)
+	}
+	return sched, nil
+}
+
+func parseClock(raw string) (int, error) {
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of the day '%s', HH:MM expected", raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s *Schedule) Active(t time.Time) bool {
+	if len(s.ranges) == 0 {
+		return true
+	}
+	t = t.In(s.location)
+	for i := range s.ranges {
+		if s.ranges[i].active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextActive returns the closest moment the schedule becomes active at, t itself if it is already active
+func (s *Schedule) NextActive(t time.Time) time.Time {
+	if s.Active(t) {
+		return t
+	}
+
+	t = t.In(s.location)
+	var next time.Time
+	// inactive period ends at the start of some range within a week
+	for day := range 8 {
+		date := t.AddDate(0, 0, day)
+		for _, r := range s.ranges {
+			if !r.onDay(date.Weekday()) {
+				continue
+			}
+			start := time.Date(date.Year(), date.Month(), date.Day(), r.from/60, r.from%60, 0, 0, s.location)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,138 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+)
+
+// at returns the moment of the week starting on Monday, 2024-01-01 in UTC
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, time.January, 1+day, hour, minute, 0, 0, time.UTC)
+}
+
+const (
+	mon = iota
+	tue
+	wed
+	thu
+	fri
+	sat
+	sun
+)
+
+var (
+	workHours = config.Schedule{
+		Timezone: "UTC",
+		Active:   []config.ScheduleRange{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "09:00", To: "18:00"}},
+	}
+	nights = config.Schedule{
+		Timezone: "UTC",
+		Active:   []config.ScheduleRange{{From: "22:00", To: "07:00"}},
+	}
+	fridayNight = config.Schedule{
+		Timezone: "UTC",
+		Active:   []config.ScheduleRange{{Days: []string{"Fri"}, From: "22:00", To: "02:00"}},
+	}
+	split = config.Schedule{
+		Timezone: "UTC",
+		Active: []config.ScheduleRange{
+			{From: "08:00", To: "12:00"},
+			{From: "14:00", To: "20:00"},
+		},
+	}
+)
+
+func TestActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule config.Schedule
+		at       time.Time
+		want     bool
+	}{
+		{"always without ranges", config.Schedule{}, at(sun, 3, 0), true},
+		{"within work hours", workHours, at(mon, 10, 0), true},
+		{"start is inclusive", workHours, at(wed, 9, 0), true},
+		{"end is exclusive", workHours, at(wed, 18, 0), false},
+		{"before work hours", workHours, at(fri, 8, 59), false},
+		{"weekend", workHours, at(sat, 10, 0), false},
+		{"night before midnight", nights, at(tue, 23, 0), true},
+		{"night after midnight", nights, at(tue, 6, 59), true},
+		{"morning", nights, at(tue, 7, 0), false},
+		{"day", nights, at(tue, 12, 0), false},
+		{"wrapped range on its day", fridayNight, at(fri, 23, 30), true},
+		{"tail of the wrapped range on the next day", fridayNight, at(sat, 1, 0), true},
+		{"tail of the wrapped range of the previous day", fridayNight, at(fri, 1, 0), false},
+		{"first of the ranges", split, at(thu, 11, 0), true},
+		{"between the ranges", split, at(thu, 13, 0), false},
+		{"second of the ranges", split, at(thu, 19, 59), true},
+		{
+			name: "time zone of the schedule",
+			schedule: config.Schedule{
+				Timezone: "Europe/Berlin",
+				Active:   []config.ScheduleRange{{From: "09:00", To: "18:00"}},
+			},
+			at:   at(mon, 8, 30), // 09:30 CET
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := New(&tt.schedule)
+			if err != nil {
+				t.Fatalf("failed to create schedule: %v", err)
+			}
+			if got := sched.Active(tt.at); got != tt.want {
+				t.Errorf("Active(%s) = %v, expected %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule config.Schedule
+		at       time.Time
+		want     time.Time
+	}{
+		{"already active", workHours, at(tue, 10, 15), at(tue, 10, 15)},
+		{"later the same day", workHours, at(tue, 7, 0), at(tue, 9, 0)},
+		{"next day", workHours, at(tue, 18, 0), at(wed, 9, 0)},
+		{"after the weekend", workHours, at(fri, 19, 0), at(mon+7, 9, 0)},
+		{"night", nights, at(wed, 12, 0), at(wed, 22, 0)},
+		{"next week", fridayNight, at(sat, 2, 0), at(fri+7, 22, 0)},
+		{"second of the ranges", split, at(thu, 12, 0), at(thu, 14, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := New(&tt.schedule)
+			if err != nil {
+				t.Fatalf("failed to create schedule: %v", err)
+			}
+			if got := sched.NextActive(tt.at); !got.Equal(tt.want) {
+				t.Errorf("NextActive(%s) = %s, expected %s", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule config.Schedule
+	}{
+		{"unknown weekday", config.Schedule{Active: []config.ScheduleRange{{Days: []string{"monday"}, From: "09:00", To: "18:00"}}}},
+		{"malformed time", config.Schedule{Active: []config.ScheduleRange{{From: "9", To: "18:00"}}}},
+		{"out of range time", config.Schedule{Active: []config.ScheduleRange{{From: "09:00", To: "24:30"}}}},
+		{"unknown time zone", config.Schedule{Timezone: "Mars/Olympus", Active: []config.ScheduleRange{{From: "09:00", To: "18:00"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(&tt.schedule); err == nil {
+				t.Errorf("invalid schedule is accepted")
+			}
+		})
+	}
+}
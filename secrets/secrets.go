@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+)
+
+const (
+	SchemeEnv     = "env"     // env:TG_TOKEN - environment variable of the daemon
+	SchemeFile    = "file"    // file:~/.config/shnotify/.tg.token - file readable by the owner only
+	SchemeCmd     = "cmd"     // cmd:pass show shnotify/tg - stdout of the shell command
+	SchemeKeyring = "keyring" // keyring:tg or keyring:service=x,account=y - Secret Service item looked up with secret-tool
+
+	keyringService = "shnotify" // service attribute of the items referenced by the name only
+
+	commandTimeout = 10 * time.Second
+)
+
+var schemes = []string{SchemeEnv, SchemeFile, SchemeCmd, SchemeKeyring}
+
+// Parse splits the reference into the scheme and its argument
+func Parse(ref config.Secret) (string, string, error) {
+	scheme, arg, ok := strings.Cut(string(ref), ":")
+	if !ok {
+		// the value is not printed since it may be the secret itself
+		return "", "", fmt.Errorf("secret must be a reference with one of the schemes: %s", strings.Join(schemes, ", "))
+	}
+	if len(strings.TrimSpace(arg)) == 0 {
+		return "", "", fmt.Errorf("secret reference '%s:' has no argument", scheme)
+	}
+
+	switch scheme {
+	case SchemeEnv, SchemeFile, SchemeCmd:
+	case SchemeKeyring:
+		if _, err := keyringAttributes(arg); err != nil {
+			return "", "", err
+		}
+	default:
+		// raw tokens often contain the colon, the part before it is not printed as well
+		return "", "", fmt.Errorf("unknown secret scheme, supported: %s", strings.Join(schemes, ", "))
+	}
+	return scheme, arg, nil
+}
+
+// Resolve returns the value the reference points to. Errors never contain the value.
+func Resolve(ctx context.Context, ref config.Secret) (string, error) {
+	scheme, arg, err := Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	switch scheme {
+	case SchemeEnv:
+		var ok bool
+		if value, ok = os.LookupEnv(arg); !ok {
+			return "", fmt.Errorf("secret %s: variable is not set", ref)
+		}
+	case SchemeFile:
+		value, err = readFile(arg)
+	case SchemeCmd:
+		value, err = run(ctx, "sh", "-c", arg)
+	case SchemeKeyring:
+		attrs, _ := keyringAttributes(arg)
+		value, err = run(ctx, "secret-tool", append([]string{"lookup"}, attrs...)...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", ref, err)
+	}
+
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	return value, nil
+}
+
+// readFile reads the secret refusing the files accessible by the group or others
+func readFile(filePath string) (string, error) {
+	if rest, ok := strings.CutPrefix(filePath, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		filePath = filepath.Join(home, rest)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return "", fmt.Errorf("permissions %#o of %s are too open, run 'chmod 600 %s'", perm, filePath, filePath)
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// run returns stdout of the command, its output is not included into the errors
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("%s exited with code %d", name, exitErr.ExitCode())
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// keyringAttributes converts the reference argument into secret-tool attribute pairs
+func keyringAttributes(arg string) ([]string, error) {
+	if !strings.Contains(arg, "=") {
+		return []string{"service", keyringService, "account", arg}, nil
+	}
+
+	var attrs []string
+	for _, pair := range strings.Split(arg, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || len(key) == 0 || len(val) == 0 {
+			return nil, fmt.Errorf("invalid keyring attribute '%s', key=value expected", pair)
+		}
+		attrs = append(attrs, key, val)
+	}
+	return attrs, nil
+}
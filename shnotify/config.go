@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/config/schema"
+	"github.com/oclaw/shnotify/redact"
+	"github.com/oclaw/shnotify/types"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// support for inspection and creation of the config
+func buildConfigCommand(configPath string) (*cobra.Command, error) {
+	paths, err := config.Locations(configPath)
+	if err != nil {
+		return nil, err
+	}
+	userPath := paths[len(paths)-1]
+
+	configCommand := &cobra.Command{
+		Use:   "config",
+		Short: "validate, show and create the config",
+	}
+
+	var filePath string
+	validateCommand := &cobra.Command{
+		Use:           "validate",
+		Short:         "check the config layers for syntax errors, unknown fields and invalid values",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var files []schema.FileProblems
+			if len(filePath) != 0 {
+				problems, err := schema.ValidateFile(filePath)
+				if err != nil {
+					return err
+				}
+				if len(problems) != 0 {
+					files = append(files, schema.FileProblems{Path: filePath, Problems: problems})
+				}
+			} else {
+				cwd, _ := os.Getwd()
+				if files, err = schema.ValidateLayers(configPath, cwd); err != nil {
+					return err
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			count := 0
+			for _, f := range files {
+				for _, p := range f.Problems {
+					if p.Line != 0 {
+						fmt.Fprintf(out, "%s:%d: %s\n", f.Path, p.Line, p.Message)
+					} else {
+						fmt.Fprintf(out, "%s: %s\n", f.Path, p.Message)
+					}
+					count++
+				}
+			}
+			if count != 0 {
+				return fmt.Errorf("%d problem(s) found", count)
+			}
+			fmt.Fprintln(out, "ok")
+			return nil
+		},
+	}
+	validateCommand.Flags().StringVar(&filePath, "file", "", "check the single config file instead of all the layers")
+
+	var effective bool
+	showCommand := &cobra.Command{
+		Use:   "show",
+		Short: "print the config files or the effective config with secrets masked",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, _ := os.Getwd()
+			cfg, sources, err := config.Load(configPath, cwd)
+			if err != nil {
+				return err
+			}
+
+			redactor, err := redact.New(cfg.RedactPatterns)
+			if err != nil {
+				redactor, _ = redact.New(nil)
+			}
+
+			var text strings.Builder
+			if effective {
+				marshaled, err := yaml.Marshal(cfg)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&text, "# sources: %s\n", strings.Join(sources, ", "))
+				text.Write(marshaled)
+			} else {
+				for _, source := range sources {
+					if source == config.EnvSource {
+						continue
+					}
+					raw, err := os.ReadFile(source)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(&text, "# %s\n%s\n", source, raw)
+				}
+				if len(sources) == 0 {
+					text.WriteString("# no config files, defaults are used\n")
+				}
+			}
+			_, err = io.WriteString(cmd.OutOrStdout(), redactor.Redact(text.String()))
+			return err
+		},
+	}
+	showCommand.Flags().BoolVar(&effective, "effective", false, "show the config in use with all the layers and defaults applied")
+
+	var force bool
+	initCommand := &cobra.Command{
+		Use:   "init",
+		Short: "create the starter config answering a few questions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(userPath); err == nil && !force {
+				return fmt.Errorf("config %s already exists, use --force to overwrite it", userPath)
+			}
+			created, err := askConfig(cmd.InOrStdin(), cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			if err := created.Save(userPath); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "config saved to %s\n", userPath)
+			return nil
+		},
+	}
+	initCommand.Flags().BoolVar(&force, "force", false, "overwrite the existing config")
+
+	schemaCommand := &cobra.Command{
+		Use:   "schema",
+		Short: "print JSON Schema of the config for editor completion",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jsonSchema, err := schema.JSONSchema()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), string(jsonSchema))
+			return err
+		},
+	}
+
+	configCommand.AddCommand(validateCommand, showCommand, initCommand, schemaCommand)
+	return configCommand, nil
+}
+
+// askConfig builds the config from the answers, defaults are used for empty answers and if input is not interactive
+func askConfig(in io.Reader, out io.Writer) (*config.ShellTrackerConfig, error) {
+	scanner := bufio.NewScanner(in)
+	ask := func(question, def string) string {
+		fmt.Fprintf(out, "%s [%s]: ", question, def)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return def
+		}
+		if answer := strings.TrimSpace(scanner.Text()); len(answer) != 0 {
+			return answer
+		}
+		return def
+	}
+
+	cfg := config.DefaultShellTrackerConfig()
+
+	threshold, err := time.ParseDuration(ask("Notify about commands running longer than", "30s"))
+	if err != nil {
+		return nil, err
+	}
+	runLonger := config.Duration(threshold)
+
+	conditions := config.NotificationConditions{
+		RunLongerThan: &runLonger,
+	}
+	if raw := ask("Notify about commands still running after (empty to disable)", ""); len(raw) != 0 {
+		after, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, err
+		}
+		stillRunning := config.Duration(after)
+		conditions.StillRunningAfter = &stillRunning
+	}
+
+	cfg.Notifications = []config.Notification{
+		{
+			Type:       types.NotificationCLI,
+			Conditions: conditions,
+		},
+	}
+
+	if raw := ask("Telegram chat id (empty to notify in the terminal only)", ""); len(raw) != 0 {
+		chatID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat id: %w", err)
+		}
+		cfg.NotifierSettings.TelegramChatID = chatID
+		cfg.Notifications = append(cfg.Notifications, config.Notification{
+			Type:       types.NotificationTelegram,
+			Conditions: conditions,
+		})
+
+		if token := ask("Telegram bot token (empty to keep the saved one)", ""); len(token) != 0 {
+			if err := saveTelegramToken(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func saveTelegramToken(token string) error {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+	tokenPath := path.Join(dir, "shnotify", ".tg.token")
+	if err := os.MkdirAll(path.Dir(tokenPath), 0o700); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return os.WriteFile(tokenPath, []byte(token), 0o600)
+}
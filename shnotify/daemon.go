@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/rpc"
+	"github.com/oclaw/shnotify/types"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	reloadTimeout = 30 * time.Second
+	startTimeout  = 10 * time.Second
+	stopTimeout   = 30 * time.Second // the daemon drains the notifications before the exit
+	pollInterval  = 100 * time.Millisecond
+
+	notRunningExitCode = 3 // as 'systemctl status' does
+)
+
+// support for management of the running daemon
+func buildDaemonCommand(client *rpc.Client, cfg *config.ShellTrackerConfig, configPath string, deadline time.Duration) (*cobra.Command, error) {
+	daemonCommand := &cobra.Command{
+		Use:   "daemon",
+		Short: "manage the running shnotifyd",
+	}
+
+	reloadCommand := &cobra.Command{
+		Use:   "reload",
+		Short: "re-read the config, the running one is kept if the new config is invalid",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// reload creates notifiers which may talk to the remote services
+			ctx, cancel := context.WithTimeout(cmd.Context(), max(deadline, reloadTimeout))
+			defer cancel()
+
+			if err := client.Reload(ctx); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "config reloaded")
+			return nil
+		},
+	}
+
+	lc := &lifecycle{
+		client:     client,
+		cfg:        cfg,
+		configPath: configPath,
+		deadline:   deadline,
+	}
+
+	startCommand := &cobra.Command{
+		Use:   "start",
+		Short: "start shnotifyd in the background, nothing is done if it is running already",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return lc.start(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+	startCommand.Flags().StringVar(&lc.binary, "binary", "", "path to shnotifyd, the one next to shnotify or in PATH by default")
+	startCommand.Flags().BoolVarP(&lc.quiet, "quiet", "q", false, "print nothing if the daemon is running already (e.g. in .zshrc)")
+
+	stopCommand := &cobra.Command{
+		Use:   "stop",
+		Short: "stop shnotifyd letting it deliver the pending notifications",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return lc.stop(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+
+	restartCommand := &cobra.Command{
+		Use:   "restart",
+		Short: "stop shnotifyd if it is running and start it again",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := lc.stop(cmd.Context(), cmd.OutOrStdout()); err != nil {
+				return err
+			}
+			return lc.start(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+	restartCommand.Flags().AddFlag(startCommand.Flags().Lookup("binary"))
+
+	var asJSON bool
+	statusCommand := &cobra.Command{
+		Use:   "status",
+		Short: "show the state of shnotifyd, exits with code 3 if it is not running",
+		// the exit code is the answer, not a failure
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), deadline)
+			defer cancel()
+
+			status, err := client.Status(ctx)
+			if err != nil {
+				pid, _ := common.PIDFileOwner(cfg.PIDFilePath())
+				if pid == 0 {
+					fmt.Fprintln(cmd.OutOrStdout(), "shnotifyd is not running")
+					return &exitCodeError{code: notRunningExitCode}
+				}
+				return fmt.Errorf("shnotifyd (pid %d) does not answer: %w", pid, err)
+			}
+
+			if asJSON {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(status)
+			}
+			printStatus(cmd.OutOrStdout(), status, time.Now().Unix())
+			return nil
+		},
+	}
+	statusCommand.Flags().BoolVar(&asJSON, "json", false, "print the status as json")
+
+	daemonCommand.AddCommand(reloadCommand, startCommand, stopCommand, restartCommand, statusCommand)
+	return daemonCommand, nil
+}
+
+// lifecycle starts and stops the daemon, the pid file locked by the daemon tells if it is alive
+type lifecycle struct {
+	client     *rpc.Client
+	cfg        *config.ShellTrackerConfig
+	configPath string
+	deadline   time.Duration
+	binary     string
+	quiet      bool
+}
+
+func (lc *lifecycle) start(ctx context.Context, out io.Writer) error {
+	if status, err := lc.status(ctx); err == nil {
+		if !lc.quiet {
+			fmt.Fprintf(out, "shnotifyd is already running (pid %d)\n", status.PID)
+		}
+		return nil
+	}
+
+	// the daemon started concurrently (e.g. by another shell) is awaited instead
+	exited := make(chan error, 1)
+	if pid, _ := common.PIDFileOwner(lc.cfg.PIDFilePath()); pid == 0 {
+		daemon, err := lc.spawn()
+		if err != nil {
+			return err
+		}
+		go func() {
+			exited <- daemon.Wait()
+		}()
+	}
+
+	timeout := time.NewTimer(startTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("shnotifyd is not ready in %s, see %s", startTimeout, lc.logPath())
+		case err := <-exited:
+			if status, statusErr := lc.status(ctx); statusErr == nil {
+				// lost the race to the daemon started concurrently
+				fmt.Fprintf(out, "shnotifyd is already running (pid %d)\n", status.PID)
+				return nil
+			}
+			return fmt.Errorf("shnotifyd exited (%v), see %s", err, lc.logPath())
+		case <-ticker.C:
+			if status, err := lc.status(ctx); err == nil {
+				fmt.Fprintf(out, "shnotifyd is started (pid %d)\n", status.PID)
+				return nil
+			}
+		}
+	}
+}
+
+// spawn starts the daemon in its own session, so it outlives the terminal
+func (lc *lifecycle) spawn() (*exec.Cmd, error) {
+	binary, err := lc.daemonBinary()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(lc.cfg.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(lc.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	var args []string
+	if len(lc.configPath) != 0 {
+		absPath, err := filepath.Abs(lc.configPath)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--config", absPath)
+	}
+
+	daemon := exec.Command(binary, args...)
+	daemon.Dir = "/"
+	daemon.Stdout = logFile
+	daemon.Stderr = logFile
+	daemon.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := daemon.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", binary, err)
+	}
+	return daemon, nil
+}
+
+func (lc *lifecycle) stop(ctx context.Context, out io.Writer) error {
+	pid, err := common.PIDFileOwner(lc.cfg.PIDFilePath())
+	if err != nil {
+		return err
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, lc.deadline)
+	defer cancel()
+	if err := lc.client.Shutdown(shutdownCtx); err != nil {
+		if pid == 0 {
+			fmt.Fprintln(out, "shnotifyd is not running")
+			return nil
+		}
+		// the daemon not answering over the socket is stopped with the signal, it shuts down the same way
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed to stop shnotifyd (pid %d): %w", pid, err)
+		}
+	}
+
+	timeout := time.NewTimer(stopTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		owner, _ := common.PIDFileOwner(lc.cfg.PIDFilePath())
+		if _, err := lc.status(ctx); err != nil && owner == 0 {
+			fmt.Fprintln(out, "shnotifyd is stopped")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("shnotifyd is not stopped in %s", stopTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lc *lifecycle) status(ctx context.Context) (*types.DaemonStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, lc.deadline)
+	defer cancel()
+	return lc.client.Status(ctx)
+}
+
+func (lc *lifecycle) logPath() string {
+	return path.Join(lc.cfg.DirPath, "shnotifyd.log")
+}
+
+func (lc *lifecycle) daemonBinary() (string, error) {
+	if len(lc.binary) != 0 {
+		// the daemon is started in the root directory
+		return filepath.Abs(lc.binary)
+	}
+	if executable, err := os.Executable(); err == nil {
+		sibling := path.Join(path.Dir(executable), "shnotifyd")
+		if info, err := os.Stat(sibling); err == nil && !info.IsDir() {
+			return sibling, nil
+		}
+	}
+	binary, err := exec.LookPath("shnotifyd")
+	if err != nil {
+		return "", fmt.Errorf("shnotifyd is not found next to shnotify and in PATH, set it with --binary")
+	}
+	return binary, nil
+}
+
+func printStatus(out io.Writer, status *types.DaemonStatus, now int64) {
+	configPath := status.ConfigPath
+	if len(configPath) == 0 {
+		configPath = "default locations"
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "shnotifyd is running\n")
+	fmt.Fprintf(w, "version:\t%s\n", status.Version)
+	fmt.Fprintf(w, "pid:\t%d\n", status.PID)
+	fmt.Fprintf(w, "uptime:\t%s\n", render.HumanDuration(now-status.StartedAt))
+	fmt.Fprintf(w, "config:\t%s\n", configPath)
+	if len(status.ConfigSources) != 0 {
+		fmt.Fprintf(w, "config sources:\t%s\n", strings.Join(status.ConfigSources, ", "))
+	}
+	fmt.Fprintf(w, "socket:\t%s\n", status.Socket)
+	if len(status.Upstream) != 0 {
+		fmt.Fprintf(w, "upstream:\t%s\n", status.Upstream)
+	}
+
+	if tracker := status.Tracker; tracker != nil {
+		fmt.Fprintf(w, "pending invocations:\t%d\n", tracker.PendingInvocations)
+		fmt.Fprintf(w, "outbox:\t%d pending, %d dead\n", tracker.OutboxDepth, tracker.DeadLetters)
+		fmt.Fprintf(w, "notifiers:\t\n")
+		for _, health := range tracker.Notifiers {
+			fmt.Fprintf(w, "  %s (%s)\t%s\n", health.Name, health.Type, notifierState(&health, now))
+		}
+	}
+	_ = w.Flush()
+}
+
+func notifierState(health *types.NotifierHealth, now int64) string {
+	switch {
+	case health.LastSentAt == 0 && health.LastFailedAt == 0:
+		return "nothing sent yet"
+	case health.LastFailedAt > health.LastSentAt:
+		return fmt.Sprintf("failing since %s: %s", render.RelativeTime(health.LastFailedAt, now), health.LastError)
+	default:
+		return fmt.Sprintf("ok, last sent %s", render.RelativeTime(health.LastSentAt, now))
+	}
+}
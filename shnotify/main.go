@@ -4,47 +4,40 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/oclaw/shnotify/capture"
+	"github.com/oclaw/shnotify/common"
 	"github.com/oclaw/shnotify/config"
 	"github.com/oclaw/shnotify/core"
 	"github.com/oclaw/shnotify/rpc"
+	"github.com/oclaw/shnotify/spool"
 	"github.com/oclaw/shnotify/types"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-func initConfig() (*config.ShellTrackerConfig, error) {
-	cfg, err := config.ReadFromDefaultLoc()
+func initConfig(configPath string) (*config.ShellTrackerConfig, error) {
+	// defaults are used if there are no config files, 'shnotify config init' creates the config
+	cwd, _ := os.Getwd()
+	cfg, _, err := config.Load(configPath, cwd)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			fmt.Printf("config does not exist, will create default one")
-			cfg = config.DefaultShellTrackerConfig()
-			if err := config.SaveConfigToDefaultLoc(cfg); err != nil {
-				fmt.Printf("failed to save config: %v", err)
-				return nil, err
-			}
-		} else {
-			fmt.Printf("failed to read config from default location, err: %v", err)
-			return nil, err
-		}
+		return nil, err
 	}
-
-	// standalone params
-	cfg.InitMode = config.NotifierInitOnDemand
-	cfg.AsyncNotifications = false
-
 	return cfg, nil
 }
 
 // support for shell track start command
-func buildStartInvocationCommand(tracker core.InvocationTracker) (*cobra.Command, error) {
+func buildStartInvocationCommand(tracker core.InvocationTracker, cfg *config.ShellTrackerConfig, budget time.Duration) (*cobra.Command, error) {
 	var (
 		shellLine         string
 		shellInvocationId string
+		startedAt         string
 	)
 
 	machineID, err := os.Hostname()
@@ -56,13 +49,18 @@ func buildStartInvocationCommand(tracker core.InvocationTracker) (*cobra.Command
 		Use:   "save-invocation",
 		Short: "save invocation of the shell command into the storage and return the external id assigned to the execution",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), budget)
+			defer cancel()
+
 			ret, err := tracker.SaveInvocation(
-				cmd.Context(),
+				ctx,
 				&types.InvocationRequest{
 					InvocationID: types.InvocationID(shellInvocationId),
 					ShellLine:    shellLine,
 					MachineID:    machineID,
 					ParentID:     os.Getppid(),
+					TimestampMs:  epochFlag(startedAt),
+					Context:      capture.Collect(&cfg.Capture, os.Getppid()),
 				},
 			)
 			if err != nil {
@@ -74,36 +72,149 @@ func buildStartInvocationCommand(tracker core.InvocationTracker) (*cobra.Command
 	}
 	saveInvocationCommand.Flags().StringVar(&shellLine, "shell-line", "", "shell command line to put into the invocation")
 	saveInvocationCommand.Flags().StringVar(&shellInvocationId, "invocation-id", "", "externally defined invocation id (empty by default)")
+	saveInvocationCommand.Flags().StringVar(&startedAt, "started-at", "", "unix time the command is started at, e.g. $EPOCHREALTIME (time of the call by default)")
 	return saveInvocationCommand, nil
 }
 
 // support for shell track end command
-func buildNotifyCommand(tracker core.InvocationTracker) (*cobra.Command, error) {
-	var invocationID string
+func buildNotifyCommand(tracker core.InvocationTracker, budget time.Duration) (*cobra.Command, error) {
+	var (
+		invocationID string
+		finishedAt   string
+	)
 
 	notifyCommand := cobra.Command{
 		Use:   "notify",
 		Short: "trigger notification for invocation that has finished executing",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return tracker.Notify(cmd.Context(), types.InvocationID(invocationID))
+			ctx, cancel := context.WithTimeout(cmd.Context(), budget)
+			defer cancel()
+
+			return tracker.Notify(ctx, &types.NotifyRequest{
+				InvocationID: types.InvocationID(invocationID),
+				TimestampMs:  epochFlag(finishedAt),
+			})
 		},
 	}
 	notifyCommand.Flags().StringVar(&invocationID, "invocation-id", "", "shell command invocation id returned by save-invocation call")
+	notifyCommand.Flags().StringVar(&finishedAt, "finished-at", "", "unix time the command is finished at, e.g. $EPOCHREALTIME (time of the call by default)")
 	return &notifyCommand, nil
 }
 
-func setupRootCommand(tracker core.InvocationTracker) (*cobra.Command, error) {
+// epochFlag parses the optional timestamp passed by the shell hook, the time of the call is used if it is not set.
+// The malformed one is reported but does not fail the hook
+func epochFlag(value string) int64 {
+	now := (&common.DefaultClock{}).NowUnixMilli()
+	if len(value) == 0 {
+		return now
+	}
+	ms, err := common.ParseEpochMilli(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shnotify: %v, the time of the call is used\n", err)
+		return now
+	}
+	return ms
+}
+
+// globalFlags are the flags defining the commands' behavior, they are parsed before the commands are built
+type globalFlags struct {
+	configPath string
+	standalone bool
+}
+
+func globalFlagsFromArgs(args []string) globalFlags {
+	var ret globalFlags
+	parse := func(args []string, interspersed bool) []string {
+		flags := pflag.NewFlagSet("global", pflag.ContinueOnError)
+		flags.ParseErrorsWhitelist.UnknownFlags = true
+		flags.SetInterspersed(interspersed)
+		flags.SetOutput(io.Discard)
+		flags.Usage = func() {}
+		flags.StringVar(&ret.configPath, "config", ret.configPath, "")
+		flags.BoolVar(&ret.standalone, "standalone", ret.standalone, "")
+		_ = flags.Parse(args)
+		return flags.Args()
+	}
+
+	// the flags may follow the command name, but the arguments of the command started by 'run' belong to it
+	rest := parse(args, false)
+	if len(rest) != 0 {
+		parse(rest[1:], rest[0] != "run")
+	}
+	return ret
+}
+
+const defaultHookBudget = 200 * time.Millisecond
+
+func setupRootCommand(
+	cfg *config.ShellTrackerConfig,
+	flags globalFlags,
+	client *rpc.Client,
+	tracker core.InvocationTracker,
+) (*cobra.Command, error) {
+
 	root := cobra.Command{
 		Use:   os.Args[0],
 		Short: "Shell invocation tracking and notifying utility",
 	}
+	root.PersistentFlags().String("config", flags.configPath, "config file used instead of the user one (SHNOTIFY_CONFIG)")
+	root.PersistentFlags().Bool("standalone", flags.standalone, "track the commands in-process without the daemon (standalone in the config)")
+
+	deadline := time.Second * time.Duration(cfg.DeadlineSec)
+
+	// hooks are executed on every shell prompt so they must not block the terminal for long,
+	// standalone ones send the notifications by themselves
+	budget := time.Duration(cfg.HookBudget)
+	_, standalone := tracker.(*standaloneTracker)
+	switch {
+	case standalone:
+		budget = deadline
+	case budget <= 0:
+		budget = defaultHookBudget
+	}
+	spoolPath := spool.FilePath(cfg.DirPath)
+	if standalone {
+		spoolPath = "" // the in-process tracker has no daemon to replay the spool
+	}
+	hookTracker := newFailOpenTracker(tracker, spoolPath, &common.DefaultClock{})
+	root.PersistentFlags().BoolVar(&hookTracker.strict, "strict", false, "report the errors of the hooks instead of spooling or ignoring them")
+
+	saveInvocationCommand, err := buildStartInvocationCommand(hookTracker, cfg, budget)
+	if err != nil {
+		return nil, err
+	}
 
-	saveInvocationCommand, err := buildStartInvocationCommand(tracker)
+	notifyCommand, err := buildNotifyCommand(hookTracker, budget)
 	if err != nil {
 		return nil, err
 	}
 
-	notifyCommand, err := buildNotifyCommand(tracker)
+	watchCommand, err := buildWatchCommand(client)
+	if err != nil {
+		return nil, err
+	}
+
+	psCommand, err := buildPsCommand(tracker, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	runCommand, err := buildRunCommand(hookTracker, cfg, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	outboxCommand, err := buildOutboxCommand(client, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	daemonCommand, err := buildDaemonCommand(client, cfg, flags.configPath, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	configCommand, err := buildConfigCommand(flags.configPath)
 	if err != nil {
 		return nil, err
 	}
@@ -111,14 +222,22 @@ func setupRootCommand(tracker core.InvocationTracker) (*cobra.Command, error) {
 	root.AddCommand(
 		saveInvocationCommand,
 		notifyCommand,
+		watchCommand,
+		psCommand,
+		runCommand,
+		outboxCommand,
+		daemonCommand,
+		configCommand,
 	)
 	return &root, nil
 }
 
 func run(ctx context.Context) error {
-	cfg, err := initConfig()
-	if err != nil {
-		return err
+	flags := globalFlagsFromArgs(os.Args[1:])
+	cfg, cfgErr := initConfig(flags.configPath)
+	if cfgErr != nil {
+		// config commands have to work with the broken config to be able to fix it
+		cfg = config.DefaultShellTrackerConfig()
 	}
 
 	client, err := rpc.NewClient(cfg.RPCSocketName)
@@ -126,14 +245,30 @@ func run(ctx context.Context) error {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(cfg.DeadlineSec))
-	defer cancel()
+	var tracker core.InvocationTracker = client
+	if cfgErr == nil && (flags.standalone || cfg.Standalone) {
+		if tracker, err = newStandaloneTracker(flags.configPath); err != nil {
+			return err
+		}
+	}
 
-	root, err := setupRootCommand(client)
+	root, err := setupRootCommand(cfg, flags, client, tracker)
 	if err != nil {
 		return err
 	}
 
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		for c := cmd; c != nil; c = c.Parent() {
+			if c.Name() == "config" {
+				return nil
+			}
+		}
+		if cfgErr != nil {
+			return fmt.Errorf("failed to read config: %w", cfgErr)
+		}
+		return nil
+	}
+
 	return root.ExecuteContext(ctx)
 }
 
@@ -142,8 +277,11 @@ func main() {
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	// TODO determine and ignore errors caused by absense of the daemon
 	if err := run(ctx); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		fmt.Printf("failed to run shnotify: %v\n", err)
 		os.Exit(1)
 	}
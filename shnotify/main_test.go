@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestGlobalFlagsFromArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want globalFlags
+	}{
+		{"before the command", []string{"--config", "x.yaml", "ps"}, globalFlags{configPath: "x.yaml"}},
+		{"after the command", []string{"ps", "--config", "x.yaml"}, globalFlags{configPath: "x.yaml"}},
+		{"after the subcommand", []string{"daemon", "start", "--config=x.yaml", "--standalone"}, globalFlags{configPath: "x.yaml", standalone: true}},
+		{"among the unknown flags", []string{"save-invocation", "--shell-line", "ls --config y.yaml", "--config", "x.yaml"}, globalFlags{configPath: "x.yaml"}},
+		{"flags of run", []string{"run", "--standalone", "--", "make", "--config", "y.yaml"}, globalFlags{standalone: true}},
+		{"arguments of the command started by run", []string{"run", "make", "--config", "y.yaml"}, globalFlags{}},
+		{"no flags", []string{"ps"}, globalFlags{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := globalFlagsFromArgs(tt.args); got != tt.want {
+				t.Errorf("globalFlagsFromArgs(%q) = %+v, expected %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oclaw/shnotify/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// support for inspection of the notifications awaiting delivery
+func buildOutboxCommand(client *rpc.Client, deadline time.Duration) (*cobra.Command, error) {
+	outboxCommand := &cobra.Command{
+		Use:   "outbox",
+		Short: "inspect and manage notifications awaiting delivery",
+	}
+
+	var asJSON bool
+	listCommand := &cobra.Command{
+		Use:   "list",
+		Short: "list pending notifications and dead letters",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), deadline)
+			defer cancel()
+
+			jobs, err := client.ListOutbox(ctx)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(jobs)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "JOB\tSTATE\tNOTIFIER\tATTEMPTS\tNEXT ATTEMPT\tCOMMAND\tLAST ERROR")
+			for _, job := range jobs {
+				state, next := "pending", time.Unix(job.NextAttemptAt, 0).Format(time.DateTime)
+				switch {
+				case job.Dead:
+					state, next = "dead", "-"
+				case job.Deferred:
+					state = "deferred"
+				}
+				command := fmt.Sprintf("<digest of %d>", len(job.Data.Digest))
+				if job.Data.Invocation != nil {
+					command = job.Data.Invocation.ShellLine
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+					job.ID,
+					state,
+					job.Notifier,
+					job.Attempts,
+					next,
+					command,
+					job.LastError,
+				)
+			}
+			return w.Flush()
+		},
+	}
+	listCommand.Flags().BoolVar(&asJSON, "json", false, "print jobs as json")
+
+	var retryAll bool
+	retryCommand := &cobra.Command{
+		Use:   "retry [job-id]",
+		Short: "deliver the notification right now (dead letters are revived)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return outboxAction(cmd, args, retryAll, deadline, client.RetryOutbox, "retried")
+		},
+	}
+	retryCommand.Flags().BoolVar(&retryAll, "all-dead", false, "retry all the dead letters")
+
+	var dropAll bool
+	dropCommand := &cobra.Command{
+		Use:   "drop [job-id]",
+		Short: "remove the notification from the outbox",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return outboxAction(cmd, args, dropAll, deadline, client.DropOutbox, "dropped")
+		},
+	}
+	dropCommand.Flags().BoolVar(&dropAll, "all-dead", false, "drop all the dead letters")
+
+	outboxCommand.AddCommand(
+		listCommand,
+		retryCommand,
+		dropCommand,
+	)
+	return outboxCommand, nil
+}
+
+func outboxAction(
+	cmd *cobra.Command,
+	args []string,
+	allDead bool,
+	deadline time.Duration,
+	action func(ctx context.Context, jobID string) (int, error),
+	verb string,
+) error {
+	if len(args) == 0 && !allDead {
+		return fmt.Errorf("either job id or --all-dead must be provided")
+	}
+	if len(args) != 0 && allDead {
+		return fmt.Errorf("job id and --all-dead are mutually exclusive")
+	}
+
+	var jobID string
+	if len(args) != 0 {
+		jobID = args[0]
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), deadline)
+	defer cancel()
+
+	affected, err := action(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "%d notification(s) %s\n", affected, verb)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oclaw/shnotify/core"
+
+	"github.com/spf13/cobra"
+)
+
+// support for listing of the commands currently running in all the tracked shells
+func buildPsCommand(tracker core.InvocationTracker, deadline time.Duration) (*cobra.Command, error) {
+	var asJSON bool
+
+	psCommand := &cobra.Command{
+		Use:   "ps",
+		Short: "list tracked commands that are currently running",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), deadline)
+			defer cancel()
+
+			invocations, err := tracker.ListInvocations(ctx)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(invocations)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "INVOCATION\tELAPSED\tMACHINE\tPPID\tSHELL\tCOMMAND")
+			for _, inv := range invocations {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+					inv.Record.InvocationID,
+					time.Duration(inv.ElapsedTime)*time.Second,
+					inv.Record.MachineID,
+					inv.Record.ParentID,
+					parentState(inv.ParentAlive),
+					inv.Record.ShellLine,
+				)
+			}
+			return w.Flush()
+		},
+	}
+	psCommand.Flags().BoolVar(&asJSON, "json", false, "print invocations as json")
+	return psCommand, nil
+}
+
+func parentState(alive *bool) string {
+	switch {
+	case alive == nil:
+		return "unknown"
+	case *alive:
+		return "alive"
+	default:
+		return "dead"
+	}
+}
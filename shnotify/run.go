@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oclaw/shnotify/capture"
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/redact"
+	"github.com/oclaw/shnotify/types"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultTailLines = 20
+	defaultTailBytes = 4096
+)
+
+// exitCodeError makes the wrapper exit with the same code as the wrapped command
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
+
+// support for direct monitoring of a single command (without the shell hooks)
+func buildRunCommand(
+	tracker core.InvocationTracker,
+	cfg *config.ShellTrackerConfig,
+	deadline time.Duration,
+) (*cobra.Command, error) {
+
+	tailCfg := cfg.OutputTail
+	if tailCfg.Lines <= 0 {
+		tailCfg.Lines = defaultTailLines
+	}
+	if tailCfg.Bytes <= 0 {
+		tailCfg.Bytes = defaultTailBytes
+	}
+
+	machineID, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get machine id: %w", err)
+	}
+
+	runCommand := &cobra.Command{
+		Use:   "run [flags] -- command [args...]",
+		Short: "run the command, track its execution and attach the tail of its output to the notification",
+		Args:  cobra.MinimumNArgs(1),
+		// errors of the wrapped command are reported by the command itself
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			redactor, err := redact.New(cfg.RedactPatterns)
+			if err != nil {
+				return err
+			}
+
+			// the child is started first for its pid to be saved, /kill of the telegram bot terminates it
+			var (
+				id      types.InvocationID
+				started time.Time
+			)
+			tail := common.NewTailBuffer(tailCfg.Lines, tailCfg.Bytes)
+			exitCode, runErr := runChild(args, tail, func(pid int) {
+				started = time.Now()
+				saveCtx, cancel := context.WithTimeout(cmd.Context(), deadline)
+				defer cancel()
+				id, err = tracker.SaveInvocation(saveCtx, &types.InvocationRequest{
+					ShellLine:   shellJoin(args),
+					MachineID:   machineID,
+					ParentID:    os.Getpid(), // wrapper lives exactly as long as the command
+					ChildPID:    pid,
+					TimestampMs: started.UnixMilli(),
+					Context:     capture.Collect(&cfg.Capture, os.Getppid()),
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "shnotify: the command is not tracked: %v\n", err)
+				}
+			})
+
+			if len(id) != 0 {
+				// the monotonic clock measures the execution regardless of the wall clock adjustments
+				elapsed := time.Since(started)
+				notifyCtx, cancel := context.WithTimeout(context.WithoutCancel(cmd.Context()), deadline)
+				defer cancel()
+				if err := tracker.Notify(notifyCtx, &types.NotifyRequest{
+					InvocationID: id,
+					TimestampMs:  started.UnixMilli() + elapsed.Milliseconds(),
+					ExecTimeMs:   elapsed.Milliseconds(),
+					ExitCode:     &exitCode,
+					OutputTail:   redactor.Redact(tail.String()),
+				}); err != nil {
+					return err
+				}
+			}
+
+			if runErr != nil {
+				return runErr
+			}
+			if exitCode != 0 {
+				return &exitCodeError{code: exitCode}
+			}
+			return nil
+		},
+	}
+	runCommand.Flags().SetInterspersed(false) // everything after the command belongs to it
+	runCommand.Flags().IntVar(&tailCfg.Lines, "tail-lines", tailCfg.Lines, "number of the last output lines to attach to the notification")
+	runCommand.Flags().IntVar(&tailCfg.Bytes, "tail-bytes", tailCfg.Bytes, "max size of the output attached to the notification")
+	return runCommand, nil
+}
+
+// runChild executes the command teeing its output into the tail buffer and returns its exit code.
+// Started is called with the pid of the command right after its start.
+func runChild(args []string, tail io.Writer, started func(pid int)) (int, error) {
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = io.MultiWriter(os.Stdout, tail)
+	child.Stderr = io.MultiWriter(os.Stderr, tail)
+
+	// the child shares the terminal and receives keyboard signals by itself, the wrapper outlives them
+	// to report the result (caught, not ignored: the ignored ones would be inherited by the child).
+	// The rest are forwarded to let it finish properly
+	keyboard := make(chan os.Signal, 1)
+	signal.Notify(keyboard, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(keyboard)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	if err := child.Start(); err != nil {
+		return 127, err
+	}
+	started(child.Process.Pid)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				_ = child.Process.Signal(sig)
+			case <-keyboard:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := child.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) != 0 && !strings.ContainsAny(arg, " \t\n'\"\\$`;&|<>()*?[]{}~#!") {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/spool"
+	"github.com/oclaw/shnotify/types"
+)
+
+// failOpenTracker keeps the shell hooks working without the daemon: the calls it does not accept are spooled
+// and replayed by the daemon at its startup, the rest of the errors are ignored unless strict mode is enabled
+type failOpenTracker struct {
+	core.InvocationTracker
+
+	spoolPath string // empty if the calls are not spooled
+	gen       types.InvocationIDGen
+	clock     common.Clock
+	strict    bool
+}
+
+func newFailOpenTracker(tracker core.InvocationTracker, spoolPath string, clock common.Clock) *failOpenTracker {
+	return &failOpenTracker{
+		InvocationTracker: tracker,
+		spoolPath:         spoolPath,
+		gen:               core.UUIDInvocationGen,
+		clock:             clock,
+	}
+}
+
+func (ft *failOpenTracker) SaveInvocation(ctx context.Context, req *types.InvocationRequest) (types.InvocationID, error) {
+	if ft.strict {
+		return ft.InvocationTracker.SaveInvocation(ctx, req)
+	}
+
+	// id and time of the call are fixed by the client for the spooled event to be replayed as it happened
+	spooled := *req
+	if len(spooled.InvocationID) == 0 {
+		id, err := ft.gen()
+		if err != nil {
+			return "", err
+		}
+		spooled.InvocationID = id
+	}
+	spooled.Timestamp, spooled.TimestampMs = common.Stamp(ft.clock, spooled.Timestamp, spooled.TimestampMs)
+
+	_, err := ft.InvocationTracker.SaveInvocation(ctx, &spooled)
+	if ft.unreachable(err) {
+		ft.spool(&spool.Event{Kind: spool.EventSaveInvocation, Save: &spooled})
+	}
+	return spooled.InvocationID, nil
+}
+
+func (ft *failOpenTracker) Notify(ctx context.Context, req *types.NotifyRequest) error {
+	if ft.strict {
+		return ft.InvocationTracker.Notify(ctx, req)
+	}
+
+	spooled := *req
+	spooled.Timestamp, spooled.TimestampMs = common.Stamp(ft.clock, spooled.Timestamp, spooled.TimestampMs)
+
+	err := ft.InvocationTracker.Notify(ctx, &spooled)
+	if ft.unreachable(err) {
+		ft.spool(&spool.Event{Kind: spool.EventNotify, Notify: &spooled})
+	}
+	return nil
+}
+
+func (ft *failOpenTracker) spool(ev *spool.Event) {
+	if err := spool.Append(ft.spoolPath, ev); err != nil {
+		fmt.Fprintf(os.Stderr, "shnotify: the daemon is not running and the event is lost: %v\n", err)
+	}
+}
+
+// unreachable reports the daemon which is not running: connection to its socket failed, so it has not received the call for sure.
+// The timed out calls may be still handled by the daemon, and the other errors are not about the socket, so they are not spooled
+func (ft *failOpenTracker) unreachable(err error) bool {
+	if len(ft.spoolPath) == 0 {
+		return false
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		return false
+	}
+	return errors.Is(opErr.Err, syscall.ENOENT) || errors.Is(opErr.Err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/events"
+	"github.com/oclaw/shnotify/types"
+)
+
+// standaloneTracker runs the tracker of the daemon in the client process over the same storage.
+// It is created on the first call, so the commands not tracking anything do not touch the storage.
+type standaloneTracker struct {
+	cfg *config.ShellTrackerConfig
+
+	once    sync.Once
+	tracker core.InvocationTracker
+	err     error
+}
+
+var _ core.InvocationTracker = (*standaloneTracker)(nil)
+
+// newStandaloneTracker creates the tracker for the config read the same way the daemon does it,
+// project configs are applied by the tracker per invocation
+func newStandaloneTracker(configPath string) (*standaloneTracker, error) {
+	cfg, _, err := config.Load(configPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing runs in the background of the short living process, notifications are sent before the exit
+	cfg.InitMode = config.NotifierInitOnDemand
+	cfg.AsyncNotifications = false
+	cfg.BackgroundTasks = false
+
+	return &standaloneTracker{
+		cfg: cfg,
+	}, nil
+}
+
+func (st *standaloneTracker) get() (core.InvocationTracker, error) {
+	st.once.Do(func() {
+		st.tracker, st.err = core.NewInvocationTracker(st.cfg, &common.DefaultClock{}, core.UUIDInvocationGen, events.NewBus())
+	})
+	return st.tracker, st.err
+}
+
+func (st *standaloneTracker) SaveInvocation(ctx context.Context, req *types.InvocationRequest) (types.InvocationID, error) {
+	tracker, err := st.get()
+	if err != nil {
+		return "", err
+	}
+	return tracker.SaveInvocation(ctx, req)
+}
+
+func (st *standaloneTracker) Notify(ctx context.Context, req *types.NotifyRequest) error {
+	tracker, err := st.get()
+	if err != nil {
+		return err
+	}
+	return tracker.Notify(ctx, req)
+}
+
+func (st *standaloneTracker) ListInvocations(ctx context.Context) ([]types.RunningInvocation, error) {
+	tracker, err := st.get()
+	if err != nil {
+		return nil, err
+	}
+	return tracker.ListInvocations(ctx)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/notify/render"
+	"github.com/oclaw/shnotify/rpc"
+	"github.com/oclaw/shnotify/types"
+
+	"github.com/spf13/cobra"
+)
+
+type watchFilter struct {
+	kinds     []string
+	machineID string
+	pattern   *regexp.Regexp
+}
+
+func (f *watchFilter) match(ev *types.Event) bool {
+	if len(f.kinds) != 0 && !slices.Contains(f.kinds, string(ev.Kind)) {
+		return false
+	}
+	if len(f.machineID) != 0 && ev.MachineID != f.machineID {
+		return false
+	}
+	if f.pattern != nil && !f.pattern.MatchString(ev.ShellLine) {
+		return false
+	}
+	return true
+}
+
+// support for live view of the tracker events
+func buildWatchCommand(client *rpc.Client) (*cobra.Command, error) {
+	var (
+		filter  watchFilter
+		pattern string
+		asJSON  bool
+	)
+
+	watchCommand := &cobra.Command{
+		Use:   "watch",
+		Short: "stream tracker events (invocation_started, invocation_finished, notification_sent) from the daemon",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(pattern) != 0 {
+				var err error
+				if filter.pattern, err = regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("invalid --match pattern: %w", err)
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			encoder := json.NewEncoder(out)
+
+			err := client.Watch(cmd.Context(), func(ev *types.Event) error {
+				if !filter.match(ev) {
+					return nil
+				}
+				if asJSON {
+					return encoder.Encode(ev)
+				}
+				_, err := fmt.Fprintln(out, formatEvent(ev))
+				return err
+			})
+			return common.IgnoreErr(err, context.Canceled)
+		},
+	}
+	watchCommand.Flags().StringSliceVar(&filter.kinds, "kind", nil, "event kinds to show (all by default)")
+	watchCommand.Flags().StringVar(&filter.machineID, "machine", "", "show events of the given machine only")
+	watchCommand.Flags().StringVar(&pattern, "match", "", "show events whose command line matches the regular expression")
+	watchCommand.Flags().BoolVar(&asJSON, "json", false, "print raw events as newline delimited json")
+	return watchCommand, nil
+}
+
+func formatEvent(ev *types.Event) string {
+	ts := time.Unix(ev.Timestamp, 0).Format(time.TimeOnly)
+	line := fmt.Sprintf("%s %-20s %s", ts, ev.Kind, ev.InvocationID)
+	if len(ev.MachineID) != 0 {
+		line += fmt.Sprintf(" [%s]", ev.MachineID)
+	}
+	if len(ev.ShellLine) != 0 {
+		line += fmt.Sprintf(" '%s'", ev.ShellLine)
+	}
+	switch ev.Kind {
+	case types.EventInvocationFinished, types.EventInvocationAbandoned:
+		switch {
+		case ev.ExecTimeMs != 0:
+			line += fmt.Sprintf(" (%s)", render.PreciseDuration(ev.ExecTimeMs))
+		case ev.ExecTime != 0:
+			line += fmt.Sprintf(" (%d sec)", ev.ExecTime)
+		}
+	case types.EventNotificationSent:
+		line += fmt.Sprintf(" via %s", ev.Notifier)
+	}
+	return line
+}
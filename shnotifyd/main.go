@@ -2,70 +2,288 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/oclaw/shnotify/common"
 	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/config/schema"
 	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/events"
+	"github.com/oclaw/shnotify/notify/telegram"
+	"github.com/oclaw/shnotify/rpc"
 	rpcserver "github.com/oclaw/shnotify/rpc/server"
+	"github.com/oclaw/shnotify/spool"
+	"github.com/oclaw/shnotify/systemd"
+	"github.com/oclaw/shnotify/upstream"
 
 	"github.com/spf13/cobra"
 )
 
-func initConfig() (*config.ShellTrackerConfig, error) {
-	cfg, err := config.ReadFromDefaultLoc()
+func initConfig(configPath string) (*config.ShellTrackerConfig, error) {
+	if err := validateLayers(configPath); err != nil {
+		return nil, err
+	}
+
+	// project layers are applied per invocation based on its working directory
+	cfg, sources, err := config.Load(configPath, "")
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			fmt.Printf("config does not exist, will create default one")
-			cfg = config.DefaultShellTrackerConfig()
-			if err := config.SaveConfigToDefaultLoc(cfg); err != nil {
-				fmt.Printf("failed to save config: %v", err)
-				return nil, err
-			}
-		} else {
-			fmt.Printf("failed to read config from default location, err: %v", err)
-			return nil, err
+		fmt.Printf("failed to read config, err: %v\n", err)
+		return nil, err
+	}
+	if !slices.ContainsFunc(sources, func(s string) bool { return s != config.EnvSource }) {
+		fmt.Printf("config does not exist, using defaults. Run 'shnotify config init' to create one\n")
+	}
+
+	setRuntimeParams(cfg)
+
+	return cfg, nil
+}
+
+// validateLayers fails on the problems of the config files, ignored environment overrides are only reported
+func validateLayers(configPath string) error {
+	files, err := schema.ValidateLayers(configPath, "")
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	invalid := slices.DeleteFunc(files, func(f schema.FileProblems) bool {
+		if f.Path != config.EnvSource {
+			return false
+		}
+		for _, p := range f.Problems {
+			fmt.Printf("warning: %s: %s\n", f.Path, p)
 		}
+		return true
+	})
+	if len(invalid) != 0 {
+		return fmt.Errorf("invalid config (see 'shnotify config validate'):\n%w", schema.LayersError(invalid))
 	}
+	return nil
+}
 
+func setRuntimeParams(cfg *config.ShellTrackerConfig) {
 	cfg.InitMode = config.NotifierInitOnStartup
 	cfg.AsyncNotifications = true // to avoid blocking of the user terminal longer than needed. May be customized later
-
-	return cfg, nil
+	cfg.BackgroundTasks = true
 }
 
-func run(ctx context.Context) error {
-	cfg, err := initConfig()
+func run(ctx context.Context, configPath string) error {
+	// registered before the startup work since SIGHUP terminates the process by default
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// taken first for the sockets not to leak into the processes started by the daemon
+	inherited, err := systemd.Listeners()
 	if err != nil {
 		return err
 	}
 
-	shellTracker, err := core.NewInvocationTracker(cfg, &common.DefaultClock{}, core.UUIDInvocationGen)
+	// stopped on signals and on the request of the client
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	startedAt := time.Now().Unix()
+
+	cfg, err := initConfig(configPath)
 	if err != nil {
 		return err
 	}
 
-	server, err := rpcserver.NewServer(cfg, shellTracker)
+	if err := os.MkdirAll(cfg.DirPath, os.ModePerm); err != nil {
+		return err
+	}
+	release, err := common.LockPIDFile(cfg.PIDFilePath())
+	if err != nil {
+		return fmt.Errorf("daemon is already running: %w", err)
+	}
+	defer release()
+
+	bus := events.NewBus()
+
+	var (
+		shellTracker core.InvocationTracker
+		applier      configApplier
+		shutdown     func(ctx context.Context) // sends what the tracker holds before the exit
+	)
+	if cfg.Upstream != nil {
+		forwarder, err := upstream.NewForwarder(ctx, cfg.Upstream, &common.DefaultClock{}, core.UUIDInvocationGen, bus)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := common.IgnoreErr(forwarder.Run(ctx), context.Canceled); err != nil {
+				fmt.Printf("upstream forwarder finalized with error %v\n", err)
+			}
+		}()
+		shellTracker = forwarder
+	} else {
+		tracker, err := core.NewInvocationTracker(cfg, &common.DefaultClock{}, core.UUIDInvocationGen, bus)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := common.IgnoreErr(tracker.Run(ctx), context.Canceled); err != nil {
+				fmt.Printf("tracker background tasks finalized with error %v\n", err)
+			}
+		}()
+		shellTracker = tracker
+		applier = tracker
+		shutdown = tracker.Shutdown
+
+		if cfg.NotifierSettings.TelegramBot != nil {
+			if err := startTelegramBot(ctx, cfg, tracker); err != nil {
+				return err
+			}
+		}
+	}
+
+	reloader := newConfigReloader(cfg, configPath, applier)
+	go func() {
+		if err := common.IgnoreErr(reloader.Run(ctx, hup, cfg.ConfigPollInterval), context.Canceled); err != nil {
+			fmt.Printf("config reloader finalized with error %v\n", err)
+		}
+	}()
+
+	control := &daemonControl{
+		cfg:        cfg,
+		configPath: configPath,
+		startedAt:  startedAt,
+		tracker:    shellTracker,
+		stop:       stop,
+	}
+
+	server, err := rpcserver.NewServer(cfg, shellTracker, bus, reloader, control, inherited)
 	if err != nil {
 		return err
 	}
 
-	return server.Serve(ctx)
+	err = server.Serve(ctx, func() {
+		notifySystemd(ctx, cfg)
+		// the hooks spool the events while the socket is not accepting, the replay starts once it does
+		go replaySpools(ctx, cfg, shellTracker)
+	})
+	if err := systemd.Notify("STOPPING=1"); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	grace := time.Duration(cfg.ShutdownGracePeriod)
+	if grace <= 0 {
+		grace = defaultShutdownGracePeriod
+	}
+	fmt.Printf("shutting down, grace period %s\n", grace)
+	graceCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	server.Shutdown(graceCtx)
+	if shutdown != nil {
+		shutdown(graceCtx)
+	}
+	fmt.Printf("daemon is stopped\n")
+	return err
+}
+
+const defaultShutdownGracePeriod = 5 * time.Second
+
+// notifySystemd reports the readiness of the daemon and keeps its watchdog alive, it does nothing outside of systemd
+func notifySystemd(ctx context.Context, cfg *config.ShellTrackerConfig) {
+	if err := systemd.Notify("READY=1"); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	timeout, ok := systemd.WatchdogInterval()
+	if !ok {
+		return
+	}
+	client, err := rpc.NewClient(cfg.RPCSocketName)
+	if err != nil {
+		fmt.Printf("watchdog is not started: %v\n", err)
+		return
+	}
+	go func() {
+		// the daemon is alive while it answers over its socket
+		alive := func() bool {
+			pingCtx, cancel := context.WithTimeout(ctx, timeout/4)
+			defer cancel()
+			_, err := client.ListInvocations(pingCtx)
+			return err == nil
+		}
+		if err := common.IgnoreErr(systemd.Watchdog(ctx, alive), context.Canceled); err != nil {
+			fmt.Printf("systemd watchdog finalized with error %v\n", err)
+		}
+	}()
+}
+
+// spoolCheckInterval is the period the spool of the hooks is checked with while the daemon is running
+const spoolCheckInterval = 10 * time.Second
+
+// replaySpools replays the spool left while the daemon was not running and picks up the events
+// the hooks spool later, e.g. the ones which failed to connect while the daemon was starting
+func replaySpools(ctx context.Context, cfg *config.ShellTrackerConfig, tracker spool.Tracker) {
+	ticker := time.NewTicker(spoolCheckInterval)
+	defer ticker.Stop()
+	for {
+		replaySpool(ctx, cfg, tracker)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func replaySpool(ctx context.Context, cfg *config.ShellTrackerConfig, tracker spool.Tracker) {
+	filePath := spool.FilePath(cfg.DirPath)
+	replayed, failed, err := spool.Replay(ctx, filePath, tracker, func(err error) {
+		fmt.Printf("failed to replay spooled event: %v\n", err)
+	})
+	if err := common.IgnoreErr(err, context.Canceled); err != nil {
+		fmt.Printf("failed to replay spooled events: %v\n", err)
+	}
+	if replayed != 0 || failed != 0 {
+		fmt.Printf("replayed %d spooled events, %d failed and kept in %s\n", replayed, failed, spool.FailedPath(filePath))
+	}
+}
+
+// startTelegramBot runs the loop answering the bot commands, the bot settings are applied at the startup only
+func startTelegramBot(ctx context.Context, cfg *config.ShellTrackerConfig, control telegram.Controller) error {
+	token, err := telegram.ResolveToken(ctx, &cfg.NotifierSettings)
+	if err != nil {
+		return fmt.Errorf("telegram bot: %w", err)
+	}
+	bot, err := telegram.NewBot(token, &cfg.NotifierSettings, control, &common.DefaultClock{})
+	if err != nil {
+		return fmt.Errorf("telegram bot: %w", err)
+	}
+	go func() {
+		if err := common.IgnoreErr(bot.Run(ctx), context.Canceled); err != nil {
+			fmt.Printf("telegram bot finalized with error %v\n", err)
+		}
+	}()
+	return nil
 }
 
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	var configPath string
 	root := cobra.Command{
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return common.IgnoreErr(run(cmd.Context()), context.Canceled)
+			return common.IgnoreErr(run(cmd.Context(), configPath), context.Canceled)
 		},
 	}
+	root.PersistentFlags().StringVar(&configPath, "config", os.Getenv(config.ConfigPathEnv), "config file used instead of the user one (SHNOTIFY_CONFIG)")
+
+	installCommand, err := buildInstallUnitCommand(&configPath)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	root.AddCommand(installCommand)
 
 	if err := root.ExecuteContext(ctx); err != nil {
 		os.Exit(1)
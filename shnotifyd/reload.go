@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+)
+
+type configApplier interface {
+	ApplyConfig(ctx context.Context, cfg *config.ShellTrackerConfig) error
+}
+
+// configReloader re-reads the config on SIGHUP, change of the file or rpc request and applies it to the tracker
+type configReloader struct {
+	configPath string        // explicit user config, empty for the default location
+	applier    configApplier // nil if the tracker cannot be reconfigured (federation mode)
+
+	mu      sync.Mutex
+	current *config.ShellTrackerConfig
+}
+
+var _ core.Reloader = (*configReloader)(nil)
+
+func newConfigReloader(cfg *config.ShellTrackerConfig, configPath string, applier configApplier) *configReloader {
+	return &configReloader{
+		configPath: configPath,
+		applier:    applier,
+		current:    cfg,
+	}
+}
+
+func (r *configReloader) Reload(ctx context.Context) error {
+	if r.applier == nil {
+		return errors.New("config reload is not supported in federation mode")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := validateLayers(r.configPath); err != nil {
+		return err
+	}
+
+	cfg, _, err := config.Load(r.configPath, "")
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	setRuntimeParams(cfg)
+
+	for _, name := range restartRequired(r.current, cfg) {
+		fmt.Printf("config reload: change of '%s' requires restart of the daemon, ignored\n", name)
+	}
+
+	if err := r.applier.ApplyConfig(ctx, cfg); err != nil {
+		return fmt.Errorf("config is not applied: %w", err)
+	}
+	r.current = cfg
+	fmt.Printf("config reloaded\n")
+	return nil
+}
+
+// restartRequired returns the settings which are used at the startup only and differ in the configs
+func restartRequired(running, updated *config.ShellTrackerConfig) []string {
+	fields := []struct {
+		name       string
+		prev, next any
+	}{
+		{"dir_path", running.DirPath, updated.DirPath},
+		{"rpc_socket_name", running.RPCSocketName, updated.RPCSocketName},
+		{"rpc_listen_tcp", running.RPCListenTCP, updated.RPCListenTCP},
+		{"rpc_token", running.RPCToken, updated.RPCToken},
+		{"upstream", running.Upstream, updated.Upstream},
+		{"orphan_check_interval", running.OrphanCheckInterval, updated.OrphanCheckInterval},
+		{"outbox", running.Outbox, updated.Outbox},
+		{"config_poll_interval", running.ConfigPollInterval, updated.ConfigPollInterval},
+		{"history_size", running.HistorySize, updated.HistorySize},
+		{"shutdown_grace_period", running.ShutdownGracePeriod, updated.ShutdownGracePeriod},
+		{"notifier_settings.telegram_bot", running.NotifierSettings.TelegramBot, updated.NotifierSettings.TelegramBot},
+	}
+
+	var changed []string
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.prev, f.next) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+// Run reloads the config on the signals from hup (SIGHUP received since the startup included)
+// and, if the poll interval is set, when one of the files is modified
+func (r *configReloader) Run(ctx context.Context, hup <-chan os.Signal, pollInterval *config.Duration) error {
+	var poll <-chan time.Time
+	if pollInterval != nil && *pollInterval > 0 {
+		ticker := time.NewTicker(time.Duration(*pollInterval))
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	lastModified := r.configModTime()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-hup:
+			lastModified = r.configModTime()
+		case <-poll:
+			modified := r.configModTime()
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+		}
+
+		if err := r.Reload(ctx); err != nil {
+			fmt.Printf("config reload failed, keeping the running config: %v\n", err)
+		}
+	}
+}
+
+// configModTime returns the latest modification time of the config layers
+func (r *configReloader) configModTime() time.Time {
+	var latest time.Time
+	paths, err := config.Locations(r.configPath)
+	if err != nil {
+		return latest
+	}
+	for _, filePath := range paths {
+		if info, err := os.Stat(filePath); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/types"
+)
+
+// daemonControl reports the state of the daemon process and stops it on the request of the client
+type daemonControl struct {
+	cfg        *config.ShellTrackerConfig
+	configPath string
+	startedAt  int64
+	tracker    core.InvocationTracker
+	stop       context.CancelFunc
+}
+
+var _ core.DaemonController = (*daemonControl)(nil)
+
+func (dc *daemonControl) Status(ctx context.Context) (*types.DaemonStatus, error) {
+	status := &types.DaemonStatus{
+		Version:    common.Version(),
+		PID:        os.Getpid(),
+		StartedAt:  dc.startedAt,
+		ConfigPath: dc.configPath,
+		Socket:     dc.cfg.RPCSocketName,
+	}
+	// files are listed as they are now, the reload applies them
+	if _, sources, err := config.Load(dc.configPath, ""); err == nil {
+		status.ConfigSources = sources
+	}
+	if up := dc.cfg.Upstream; up != nil {
+		status.Upstream = up.Address
+	}
+
+	if reporter, ok := dc.tracker.(core.StatusReporter); ok {
+		var err error
+		if status.Tracker, err = reporter.Status(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+func (dc *daemonControl) Stop(ctx context.Context) error {
+	fmt.Printf("stop is requested by the client\n")
+	dc.stop()
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/oclaw/shnotify/config"
+
+	"github.com/spf13/cobra"
+)
+
+const unitName = "shnotifyd"
+
+var serviceUnit = template.Must(template.New("service").Parse(`[Unit]
+Description=shnotify daemon notifying about the finished shell commands
+Requires={{ .Name }}.socket
+After={{ .Name }}.socket
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{ .ExecStart }}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+WatchdogSec=30s
+
+[Install]
+WantedBy=default.target
+Also={{ .Name }}.socket
+`))
+
+var socketUnit = template.Must(template.New("socket").Parse(`[Unit]
+Description=shnotify daemon socket
+
+[Socket]
+ListenStream={{ .Socket }}
+SocketMode=0600
+{{- if .TCP }}
+ListenStream={{ .TCP }}
+{{- end }}
+
+[Install]
+WantedBy=sockets.target
+`))
+
+type unitParams struct {
+	Name      string
+	ExecStart string
+	Socket    string
+	TCP       string
+}
+
+// support for running the daemon as the systemd user service activated by its socket
+func buildInstallUnitCommand(configPath *string) (*cobra.Command, error) {
+	var (
+		dirPath   string
+		printOnly bool
+		force     bool
+	)
+
+	installCommand := &cobra.Command{
+		Use:   "install-unit",
+		Short: "generate systemd --user service and socket units of the daemon",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, _, err := config.Load(*configPath, "")
+			if err != nil {
+				return err
+			}
+			executable, err := os.Executable()
+			if err != nil {
+				return err
+			}
+
+			execStart := []string{quoteArg(executable)}
+			if len(*configPath) != 0 {
+				absPath, err := filepath.Abs(*configPath)
+				if err != nil {
+					return err
+				}
+				execStart = append(execStart, "--config", quoteArg(absPath))
+			}
+			params := unitParams{
+				Name:      unitName,
+				ExecStart: strings.Join(execStart, " "),
+				Socket:    cfg.RPCSocketName,
+				TCP:       cfg.RPCListenTCP,
+			}
+
+			units := []struct {
+				name     string
+				template *template.Template
+			}{
+				{unitName + ".service", serviceUnit},
+				{unitName + ".socket", socketUnit},
+			}
+			for _, unit := range units {
+				var content strings.Builder
+				if err := unit.template.Execute(&content, params); err != nil {
+					return err
+				}
+				if printOnly {
+					fmt.Fprintf(cmd.OutOrStdout(), "# %s\n%s\n", unit.name, content.String())
+					continue
+				}
+				if err := writeUnit(path.Join(dirPath, unit.name), content.String(), force); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s is written\n", path.Join(dirPath, unit.name))
+			}
+			if !printOnly {
+				fmt.Fprintf(cmd.OutOrStdout(), "run 'systemctl --user daemon-reload && systemctl --user enable --now %s.socket %s.service' to start the daemon\n", unitName, unitName)
+			}
+			return nil
+		},
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	installCommand.Flags().StringVar(&dirPath, "dir", path.Join(configDir, "systemd", "user"), "directory to write the units to")
+	installCommand.Flags().BoolVar(&printOnly, "print", false, "print the units instead of writing them")
+	installCommand.Flags().BoolVar(&force, "force", false, "overwrite the existing units")
+	return installCommand, nil
+}
+
+func writeUnit(filePath, content string, force bool) error {
+	if _, err := os.Stat(filePath); err == nil && !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", filePath)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, []byte(content), 0o644)
+}
+
+// quoteArg quotes the argument of ExecStart containing the characters systemd splits or expands
+func quoteArg(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'\\$%") {
+		return arg
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + replacer.Replace(arg) + `"`
+}
@@ -0,0 +1,185 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/types"
+)
+
+type EventKind string
+
+const (
+	EventSaveInvocation EventKind = "save-invocation"
+	EventNotify         EventKind = "notify"
+)
+
+// Event is the shell hook call made while the daemon was not available, it carries the timestamp of the call
+type Event struct {
+	Kind   EventKind                `json:"kind"`
+	Save   *types.InvocationRequest `json:"save,omitempty"`
+	Notify *types.NotifyRequest     `json:"notify,omitempty"`
+}
+
+type Tracker interface {
+	SaveInvocation(ctx context.Context, req *types.InvocationRequest) (types.InvocationID, error)
+	Notify(ctx context.Context, req *types.NotifyRequest) error
+}
+
+const (
+	fileName = "spool.jsonl"
+
+	// the spool is not limited by the daemon, it may never be started
+	maxFileSize = 16 << 20
+)
+
+func FilePath(dirPath string) string {
+	return path.Join(dirPath, fileName)
+}
+
+// FailedPath returns the file keeping the events the tracker has not accepted on replay
+func FailedPath(filePath string) string {
+	return filePath + ".failed"
+}
+
+// Append adds the event to the spool, concurrent shells append whole lines with a single write
+func Append(filePath string, ev *Event) error {
+	if info, err := os.Stat(filePath); err == nil && info.Size() > maxFileSize {
+		return fmt.Errorf("spool %s is full", filePath)
+	}
+
+	marshaled, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return appendLine(filePath, marshaled)
+}
+
+func appendLine(filePath string, line []byte) error {
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Replay passes the spooled events to the tracker in the order of the calls and removes them.
+// The spool is moved aside first, the events appended meanwhile are left for the next replay.
+// The events the tracker fails to accept are reported and kept in the FailedPath file.
+func Replay(ctx context.Context, filePath string, tracker Tracker, report func(error)) (replayed, failed int, err error) {
+	replayPath := filePath + ".replay"
+	offsetPath := replayPath + ".offset"
+	// the replay interrupted by the daemon stop is finished first
+	if _, err := os.Stat(replayPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(filePath, replayPath); err != nil {
+			return 0, 0, common.IgnoreErr(err, os.ErrNotExist)
+		}
+		if err := os.Remove(offsetPath); common.IgnoreErr(err, os.ErrNotExist) != nil {
+			return 0, 0, err
+		}
+	}
+
+	file, err := os.Open(replayPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	// the events handled before the interruption are skipped
+	offset := readOffset(offsetPath)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return replayed, failed, readErr
+		}
+		if len(line) == 0 {
+			break
+		}
+		offset += int64(len(line))
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		if len(line) != 0 {
+			if err := replay(ctx, line, tracker); err != nil {
+				if ctx.Err() != nil {
+					return replayed, failed, ctx.Err()
+				}
+				report(err)
+				// the replay is repeated at the next start if the event cannot be kept
+				if err := appendLine(FailedPath(filePath), line); err != nil {
+					return replayed, failed, fmt.Errorf("failed to keep the event: %w", err)
+				}
+				failed++
+			} else {
+				replayed++
+			}
+		}
+		if err := writeOffset(offsetPath, offset); err != nil {
+			return replayed, failed, fmt.Errorf("failed to save the replay offset: %w", err)
+		}
+	}
+
+	if err := os.Remove(replayPath); err != nil {
+		return replayed, failed, err
+	}
+	return replayed, failed, common.IgnoreErr(os.Remove(offsetPath), os.ErrNotExist)
+}
+
+// readOffset returns the size of the replayed part of the spool, 0 if nothing is replayed yet
+func readOffset(offsetPath string) int64 {
+	raw, err := os.ReadFile(offsetPath)
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+func writeOffset(offsetPath string, offset int64) error {
+	// write + rename, the offset must survive the stop in the middle of the write
+	tmpName := offsetPath + ".tmp"
+	if err := os.WriteFile(tmpName, []byte(strconv.FormatInt(offset, 10)), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, offsetPath)
+}
+
+func replay(ctx context.Context, line []byte, tracker Tracker) error {
+	var ev Event
+	if err := json.Unmarshal(line, &ev); err != nil {
+		return fmt.Errorf("malformed event: %w", err)
+	}
+
+	switch {
+	case ev.Kind == EventSaveInvocation && ev.Save != nil:
+		_, err := tracker.SaveInvocation(ctx, ev.Save)
+		return err
+	case ev.Kind == EventNotify && ev.Notify != nil:
+		return tracker.Notify(ctx, ev.Notify)
+	default:
+		return fmt.Errorf("unknown event kind '%s'", ev.Kind)
+	}
+}
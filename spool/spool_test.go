@@ -0,0 +1,207 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/oclaw/shnotify/types"
+)
+
+type fakeTracker struct {
+	calls    []string
+	failed   map[types.InvocationID]bool
+	cancel   context.CancelFunc // called instead of the call number cancelAt (from 0) if set
+	cancelAt int
+}
+
+func (ft *fakeTracker) call(kind string, id types.InvocationID) error {
+	if ft.cancel != nil && len(ft.calls) == ft.cancelAt {
+		ft.cancel()
+		return context.Canceled
+	}
+	ft.calls = append(ft.calls, kind+":"+string(id))
+	if ft.failed[id] {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (ft *fakeTracker) SaveInvocation(ctx context.Context, req *types.InvocationRequest) (types.InvocationID, error) {
+	return req.InvocationID, ft.call("save", req.InvocationID)
+}
+
+func (ft *fakeTracker) Notify(ctx context.Context, req *types.NotifyRequest) error {
+	return ft.call("notify", req.InvocationID)
+}
+
+func save(id types.InvocationID) *Event {
+	return &Event{Kind: EventSaveInvocation, Save: &types.InvocationRequest{InvocationID: id, Timestamp: 1}}
+}
+
+func notify(id types.InvocationID) *Event {
+	return &Event{Kind: EventNotify, Notify: &types.NotifyRequest{InvocationID: id, Timestamp: 2}}
+}
+
+func readLines(t *testing.T, filePath string) []string {
+	t.Helper()
+	raw, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", filePath, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		events       []*Event
+		raw          []string // lines appended after the events
+		failed       []types.InvocationID
+		wantCalls    []string
+		wantReplayed int
+		wantFailed   int
+	}{
+		{
+			name:         "in order of the calls",
+			events:       []*Event{save("a"), save("b"), notify("a"), notify("b")},
+			wantCalls:    []string{"save:a", "save:b", "notify:a", "notify:b"},
+			wantReplayed: 4,
+		},
+		{
+			name:         "failures are kept",
+			events:       []*Event{save("a"), notify("a"), save("b"), notify("b")},
+			failed:       []types.InvocationID{"a"},
+			wantCalls:    []string{"save:a", "notify:a", "save:b", "notify:b"},
+			wantReplayed: 2,
+			wantFailed:   2,
+		},
+		{
+			name:         "malformed lines are kept",
+			events:       []*Event{save("a")},
+			raw:          []string{"", "{broken", `{"kind":"unknown"}`, `{"kind":"notify"}`},
+			wantCalls:    []string{"save:a"},
+			wantReplayed: 1,
+			wantFailed:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := FilePath(t.TempDir())
+			for _, ev := range tt.events {
+				if err := Append(filePath, ev); err != nil {
+					t.Fatalf("failed to append: %v", err)
+				}
+			}
+			for _, line := range tt.raw {
+				if err := appendLine(filePath, []byte(line)); err != nil {
+					t.Fatalf("failed to append: %v", err)
+				}
+			}
+
+			tracker := &fakeTracker{failed: make(map[types.InvocationID]bool)}
+			for _, id := range tt.failed {
+				tracker.failed[id] = true
+			}
+			var reported int
+			replayed, failed, err := Replay(context.Background(), filePath, tracker, func(error) { reported++ })
+			if err != nil {
+				t.Fatalf("replay failed: %v", err)
+			}
+
+			if replayed != tt.wantReplayed || failed != tt.wantFailed || reported != tt.wantFailed {
+				t.Errorf("replayed %d, failed %d, reported %d, expected %d and %d", replayed, failed, reported, tt.wantReplayed, tt.wantFailed)
+			}
+			if !slices.Equal(tracker.calls, tt.wantCalls) {
+				t.Errorf("calls %v, expected %v", tracker.calls, tt.wantCalls)
+			}
+			if kept := readLines(t, FailedPath(filePath)); len(kept) != tt.wantFailed {
+				t.Errorf("%d events are kept as failed, expected %d: %v", len(kept), tt.wantFailed, kept)
+			}
+			for _, leftover := range []string{filePath, filePath + ".replay", filePath + ".replay.offset"} {
+				if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s is left after the replay", filepath.Base(leftover))
+				}
+			}
+		})
+	}
+}
+
+func TestReplayNothing(t *testing.T) {
+	replayed, failed, err := Replay(context.Background(), FilePath(t.TempDir()), &fakeTracker{}, func(error) {})
+	if replayed != 0 || failed != 0 || err != nil {
+		t.Errorf("Replay() = %d, %d, %v for the absent spool", replayed, failed, err)
+	}
+}
+
+func TestReplayInterrupted(t *testing.T) {
+	filePath := FilePath(t.TempDir())
+	if err := Append(filePath, save("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, _, err := Replay(ctx, filePath, &fakeTracker{cancel: cancel}, func(error) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted replay returned %v", err)
+	}
+	if _, err := os.Stat(FailedPath(filePath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("event of the interrupted replay is kept as failed")
+	}
+
+	// the hooks append to the new spool meanwhile, the interrupted replay is finished first
+	if err := Append(filePath, save("b")); err != nil {
+		t.Fatal(err)
+	}
+	tracker := &fakeTracker{}
+	replayed, _, err := Replay(context.Background(), filePath, tracker, func(error) {})
+	if err != nil || replayed != 1 || !slices.Equal(tracker.calls, []string{"save:a"}) {
+		t.Fatalf("Replay() = %d, %v with calls %v, expected the interrupted event", replayed, err, tracker.calls)
+	}
+	replayed, _, err = Replay(context.Background(), filePath, tracker, func(error) {})
+	if err != nil || replayed != 1 || !slices.Equal(tracker.calls, []string{"save:a", "save:b"}) {
+		t.Fatalf("Replay() = %d, %v with calls %v, expected the new event", replayed, err, tracker.calls)
+	}
+}
+
+func TestReplayResumed(t *testing.T) {
+	filePath := FilePath(t.TempDir())
+	for _, ev := range []*Event{save("a"), notify("a"), save("b")} {
+		if err := Append(filePath, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupted := &fakeTracker{cancel: cancel, cancelAt: 1}
+	if _, _, err := Replay(ctx, filePath, interrupted, func(error) {}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted replay returned %v", err)
+	}
+
+	// the events applied before the stop are not applied again
+	tracker := &fakeTracker{}
+	replayed, _, err := Replay(context.Background(), filePath, tracker, func(error) {})
+	if err != nil || replayed != 2 || !slices.Equal(tracker.calls, []string{"notify:a", "save:b"}) {
+		t.Fatalf("Replay() = %d, %v with calls %v, expected the rest of the events", replayed, err, tracker.calls)
+	}
+}
+
+func TestAppendFull(t *testing.T) {
+	filePath := FilePath(t.TempDir())
+	if err := Append(filePath, save("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filePath, maxFileSize+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(filePath, save("b")); err == nil {
+		t.Errorf("event is appended to the full spool")
+	}
+}
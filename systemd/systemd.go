@@ -0,0 +1,116 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// first file descriptor passed by the service manager (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation, nil if the process is not activated.
+// The environment is cleared for the children not to take the sockets.
+func Listeners() ([]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+
+		name := fmt.Sprintf("fd%d", fd)
+		if i := fd - listenFdsStart; i < len(names) && len(names[i]) != 0 {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close() // the listener holds its own copy of the descriptor
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("socket %s passed by systemd is not a listening one: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// Notify sends the state (e.g. READY=1) to the service manager, it does nothing if the service is not of notify type
+func Notify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if len(addr) == 0 {
+		return nil
+	}
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:] // abstract namespace
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// WatchdogInterval returns the watchdog timeout of the service, ok is false if the watchdog is disabled
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// Watchdog keeps the service alive sending WATCHDOG=1 twice per the timeout until the context is cancelled.
+// Alive reports if the service is still healthy, the keep-alives are skipped otherwise.
+func Watchdog(ctx context.Context, alive func() bool) error {
+	timeout, ok := WatchdogInterval()
+	if !ok {
+		return nil
+	}
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if !alive() {
+				continue
+			}
+			if err := Notify("WATCHDOG=1"); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -2,6 +2,8 @@ package types
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type InvocationID string
@@ -19,36 +21,257 @@ func InvocationGenFromStringer[T fmt.Stringer](gen func() (T, error)) Invocation
 }
 
 type InvocationRequest struct {
-	InvocationID InvocationID `json:"invocation_id,omitempty"`
-	MachineID    string       `json:"machine_id,omitempty"`
-	ParentID     int          `json:"ppid"`
-	ShellLine    string       `json:"cmd_text"`
+	InvocationID InvocationID       `json:"invocation_id,omitempty"`
+	MachineID    string             `json:"machine_id,omitempty"`
+	ParentID     int                `json:"ppid"`
+	ShellLine    string             `json:"cmd_text"`
+	Timestamp    int64              `json:"started_at,omitempty"`    // assigned by the tracker if not provided (e.g. by forwarding daemon)
+	TimestampMs  int64              `json:"started_at_ms,omitempty"` // precise start time (e.g. $EPOCHREALTIME of the shell hook), preferred to the seconds
+	Context      *InvocationContext `json:"context,omitempty"`
+	ChildPID     int                `json:"child_pid,omitempty"` // command process started by 'shnotify run', parent id is the wrapper then
+}
+
+type NotifyRequest struct {
+	InvocationID InvocationID `json:"invocation_id"`
+	Timestamp    int64        `json:"finished_at,omitempty"` // assigned by the tracker if not provided
+	TimestampMs  int64        `json:"finished_at_ms,omitempty"`
+	ExecTimeMs   int64        `json:"exec_time_ms,omitempty"` // measured by the client with the monotonic clock (e.g. 'shnotify run')
+	ExitCode     *int         `json:"exit_code,omitempty"`    // known if the command was executed by the shnotify itself
+	OutputTail   string       `json:"output_tail,omitempty"`  // last lines of the command output (redacted)
 }
 
 type ShellInvocationRecord struct {
+	InvocationID InvocationID       `json:"invocation_id"`
+	ParentID     int                `json:"ppid"`
+	MachineID    string             `json:"machine_id"`
+	ShellLine    string             `json:"cmd_text"`
+	Timestamp    int64              `json:"started_at"`
+	TimestampMs  int64              `json:"started_at_ms,omitempty"` // not set by the older versions
+	Context      *InvocationContext `json:"context,omitempty"`
+	ChildPID     int                `json:"child_pid,omitempty"`
+
+	ProgressNotified map[string]int64 `json:"progress_notified,omitempty"` // last time the in-progress notification was sent per notification entry
+	FinishedAt       int64            `json:"finished_at,omitempty"`       // set for the finished invocations kept in the storage
+	AbandonedAt      int64            `json:"abandoned_at,omitempty"`      // set if the parent shell has gone before the command finished
+}
+
+// StartedAtMs returns the start time in milliseconds
+func (rec *ShellInvocationRecord) StartedAtMs() int64 {
+	if rec.TimestampMs != 0 {
+		return rec.TimestampMs
+	}
+	return rec.Timestamp * 1000
+}
+
+// ProgressNotifiedBy returns the last time the in-progress notification of the entry was sent, 0 if it was not
+func (rec *ShellInvocationRecord) ProgressNotifiedBy(name string) int64 {
+	return rec.ProgressNotified[name]
+}
+
+// InvocationContext describes the environment the command was started in
+type InvocationContext struct {
+	Cwd        string `json:"cwd,omitempty"`
+	User       string `json:"user,omitempty"`
+	TTY        string `json:"tty,omitempty"`
+	Shell      string `json:"shell,omitempty"`
+	ShellLevel int    `json:"shlvl,omitempty"`
+
+	// terminal multiplexer the shell runs in
+	TmuxSocket   string `json:"tmux_socket,omitempty"`
+	TmuxPane     string `json:"tmux_pane,omitempty"` // '%3'
+	Screen       string `json:"screen,omitempty"`    // $STY of the screen session
+	ScreenWindow string `json:"screen_window,omitempty"`
+	Pane         string `json:"pane,omitempty"` // session:window.pane, resolved by the tracker when the notification is made
+
+	// opt-in parts
+	GitRoot     string            `json:"git_root,omitempty"`
+	GitBranch   string            `json:"git_branch,omitempty"`
+	VirtualEnv  string            `json:"virtual_env,omitempty"`
+	KubeContext string            `json:"kube_context,omitempty"`
+	Env         map[string]string `json:"env,omitempty"` // allowlisted environment variables
+}
+
+// Field returns the value by its json name, environment variables are addressed as 'env.NAME'
+func (ic *InvocationContext) Field(name string) (string, bool) {
+	if ic == nil {
+		return "", false
+	}
+	if env, ok := strings.CutPrefix(name, "env."); ok {
+		val, ok := ic.Env[env]
+		return val, ok
+	}
+
+	switch name {
+	case "cwd":
+		return ic.Cwd, true
+	case "user":
+		return ic.User, true
+	case "tty":
+		return ic.TTY, true
+	case "shell":
+		return ic.Shell, true
+	case "shlvl":
+		return strconv.Itoa(ic.ShellLevel), true
+	case "tmux_pane":
+		return ic.TmuxPane, true
+	case "screen":
+		return ic.Screen, true
+	case "pane":
+		return ic.Pane, true
+	case "git_root":
+		return ic.GitRoot, true
+	case "git_branch":
+		return ic.GitBranch, true
+	case "virtual_env":
+		return ic.VirtualEnv, true
+	case "kube_context":
+		return ic.KubeContext, true
+	default:
+		return "", false
+	}
+}
+
+// RunningInvocation is the invocation saved but not notified yet
+type RunningInvocation struct {
+	Record      *ShellInvocationRecord `json:"record"`
+	ElapsedTime int64                  `json:"elapsed_sec"`
+	ParentAlive *bool                  `json:"parent_alive,omitempty"` // nil if the parent cannot be checked (e.g. runs on the other machine)
+}
+
+// Pending reports whether the invocation is still running as far as the tracker knows
+func (rec *ShellInvocationRecord) Pending() bool {
+	return rec.FinishedAt == 0 && rec.AbandonedAt == 0
+}
+
+// HistoryEntry is the finished (or abandoned) invocation kept after its record is erased
+type HistoryEntry struct {
 	InvocationID InvocationID `json:"invocation_id"`
-	ParentID     int          `json:"ppid"`
-	MachineID    string       `json:"machine_id"`
+	MachineID    string       `json:"machine_id,omitempty"`
 	ShellLine    string       `json:"cmd_text"`
-	Timestamp    int64        `json:"started_at"`
+	Cwd          string       `json:"cwd,omitempty"`
+	StartedAt    int64        `json:"started_at"`
+	FinishedAt   int64        `json:"finished_at"`
+	ExecTime     int64        `json:"exec_time"`
+	ExecTimeMs   int64        `json:"exec_time_ms,omitempty"`
+	ExitCode     *int         `json:"exit_code,omitempty"`
+	Abandoned    bool         `json:"abandoned,omitempty"`
+}
+
+// Stats summarizes the invocations finished since the moment
+type Stats struct {
+	Since         int64          `json:"since"`
+	Running       int            `json:"running"`
+	Finished      int            `json:"finished"`
+	Failed        int            `json:"failed"` // finished with non-zero exit code
+	Abandoned     int            `json:"abandoned"`
+	TotalExecTime int64          `json:"total_exec_time"`
+	MaxExecTime   int64          `json:"max_exec_time"`
+	Top           []CommandStats `json:"top,omitempty"` // most time consuming commands
+}
+
+type CommandStats struct {
+	Command       string `json:"command"`
+	Count         int    `json:"count"`
+	TotalExecTime int64  `json:"total_exec_time"`
+}
+
+// DaemonStatus is the state of the running daemon
+type DaemonStatus struct {
+	Version       string         `json:"version"`
+	PID           int            `json:"pid"`
+	StartedAt     int64          `json:"started_at"`
+	ConfigPath    string         `json:"config_path,omitempty"` // explicit config replacing the user one
+	ConfigSources []string       `json:"config_sources,omitempty"`
+	Socket        string         `json:"socket"`
+	Upstream      string         `json:"upstream,omitempty"` // hub the invocations are forwarded to
+	Tracker       *TrackerStatus `json:"tracker,omitempty"`  // nil if the daemon forwards the invocations
+}
+
+// TrackerStatus is the state of the invocations and notifications of the tracker
+type TrackerStatus struct {
+	Notifiers          []NotifierHealth `json:"notifiers"`
+	PendingInvocations int              `json:"pending_invocations"`
+	OutboxDepth        int              `json:"outbox_depth"`
+	DeadLetters        int              `json:"dead_letters"`
+}
+
+// NotifierHealth is the outcome of the latest deliveries of the notifier instance
+type NotifierHealth struct {
+	Name         string           `json:"name"`
+	Type         NotificationType `json:"type"`
+	LastSentAt   int64            `json:"last_sent_at,omitempty"`
+	LastFailedAt int64            `json:"last_failed_at,omitempty"`
+	LastError    string           `json:"last_error,omitempty"`
 }
 
 type NotificationResult struct {
 	Message string `json:"message,omitempty"`
 }
 
+type NotificationKind string
+
+const (
+	NotificationFinished   NotificationKind = "finished"    // command has finished its execution
+	NotificationInProgress NotificationKind = "in_progress" // command is still running
+	NotificationAbandoned  NotificationKind = "abandoned"   // shell has died while the command was running
+	NotificationDigest     NotificationKind = "digest"      // batch of notifications, invocation is not set
+)
+
 type NotificationData struct {
-	Invocation   *ShellInvocationRecord
-	NowTimestamp int64
-	ExecTime     int64
+	Kind         NotificationKind       `json:"kind"`
+	Invocation   *ShellInvocationRecord `json:"invocation"`
+	NowTimestamp int64                  `json:"now"`
+	ExecTime     int64                  `json:"exec_time"`
+	ExecTimeMs   int64                  `json:"exec_time_ms,omitempty"` // not set by the older versions
+	ExitCode     *int                   `json:"exit_code,omitempty"`
+	OutputTail   string                 `json:"output_tail,omitempty"`
+	Focused      bool                   `json:"focused,omitempty"` // multiplexer pane of the command is in front of the user
+	Digest       []*NotificationData    `json:"digest,omitempty"`
 	// feel free to add more data that can be reused among notifiers
 }
 
+// OutboxJob is the notification persisted until it is delivered by the notifier
+type OutboxJob struct {
+	ID            string            `json:"id"`
+	Notifier      string            `json:"notifier"` // name of the notifier instance
+	Data          *NotificationData `json:"data"`
+	CreatedAt     int64             `json:"created_at"`
+	CreatedAtMs   int64             `json:"created_at_ms,omitempty"` // orders the jobs of the same second, not set by the older versions
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt int64             `json:"next_attempt_at"`
+	LastError     string            `json:"last_error,omitempty"`
+	Dead          bool              `json:"dead,omitempty"`     // delivery attempts are exhausted
+	Deferred      bool              `json:"deferred,omitempty"` // postponed by the quiet hours, dispatched as a new notification when due
+}
+
 type NotificationType string
 
 const (
 	NotificationCLI      NotificationType = "cli"      // trivial notification putting the text into the command line
 	NotificatonOSPush                     = "os-push"  // GUI OS notification (libnotify for linux)
 	NotificationTelegram                  = "telegram" // Notification published into the telegram bot
+	NotificationOSC                       = "osc"      // escape sequence written to the terminal of the command
 	// feel free to put here any type of supported (or proxied) notification
 )
+
+type EventKind string
+
+const (
+	EventInvocationStarted   EventKind = "invocation_started"
+	EventInvocationFinished  EventKind = "invocation_finished"
+	EventNotificationSent    EventKind = "notification_sent"
+	EventInvocationAbandoned EventKind = "invocation_abandoned"
+)
+
+// Event is published by the tracker and streamed to the watchers
+type Event struct {
+	Kind         EventKind    `json:"kind"`
+	Timestamp    int64        `json:"ts"`
+	InvocationID InvocationID `json:"invocation_id"`
+	MachineID    string       `json:"machine_id,omitempty"`
+	ParentID     int          `json:"ppid,omitempty"`
+	ShellLine    string       `json:"cmd_text,omitempty"`
+	ExecTime     int64        `json:"exec_time,omitempty"`
+	ExecTimeMs   int64        `json:"exec_time_ms,omitempty"`
+	Notifier     string       `json:"notifier,omitempty"` // name of the notifier instance
+}
@@ -0,0 +1,256 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/config"
+	"github.com/oclaw/shnotify/core"
+	"github.com/oclaw/shnotify/events"
+	"github.com/oclaw/shnotify/rpc"
+	rpctypes "github.com/oclaw/shnotify/rpc/types"
+	"github.com/oclaw/shnotify/secrets"
+	"github.com/oclaw/shnotify/types"
+)
+
+var errMalformedEvent = errors.New("malformed upstream event")
+
+const (
+	defaultRetryInterval = 5 * time.Second
+	deliveryTimeout      = 10 * time.Second
+)
+
+// Forwarder is the invocation tracker of the daemon running in federation mode.
+// Instead of evaluating conditions locally it puts every event into the durable queue
+// and delivers them one by one (preserving the order) to the hub daemon.
+type Forwarder struct {
+	config    *config.UpstreamConfig
+	clock     common.Clock
+	gen       types.InvocationIDGen
+	machineID string
+	queue     *fsQueue
+	hub       core.InvocationTracker
+	events    *events.Bus
+}
+
+var _ core.InvocationTracker = (*Forwarder)(nil)
+
+func NewForwarder(
+	ctx context.Context,
+	cfg *config.UpstreamConfig,
+	clock common.Clock,
+	gen types.InvocationIDGen,
+	bus *events.Bus,
+) (*Forwarder, error) {
+
+	if len(cfg.Address) == 0 {
+		return nil, fmt.Errorf("upstream address is not set")
+	}
+
+	network := cfg.Network
+	if len(network) == 0 {
+		network = "unix"
+	}
+	var token string
+	if len(cfg.Token) != 0 {
+		resolved, err := secrets.Resolve(ctx, cfg.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve upstream token: %w", err)
+		}
+		token = resolved
+	}
+	hub, err := rpc.NewNetworkClient(network, cfg.Address, token)
+	if err != nil {
+		return nil, err
+	}
+
+	queueDir := cfg.QueueDir
+	if len(queueDir) == 0 {
+		queueDir = path.Join(os.TempDir(), "shnotify-upstream")
+	}
+	queue, err := newFsQueue(queueDir)
+	if err != nil {
+		return nil, err
+	}
+
+	machineID, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get machine id: %w", err)
+	}
+
+	return &Forwarder{
+		config:    cfg,
+		clock:     clock,
+		gen:       gen,
+		machineID: machineID,
+		queue:     queue,
+		hub:       hub,
+		events:    bus,
+	}, nil
+}
+
+func (f *Forwarder) SaveInvocation(
+	ctx context.Context,
+	req *types.InvocationRequest,
+) (types.InvocationID, error) {
+
+	// id and timestamp are assigned here so the hub sees the same values no matter how long the event is queued
+	fwd := *req
+	if len(fwd.InvocationID) == 0 {
+		var err error
+		fwd.InvocationID, err = f.gen()
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(fwd.MachineID) == 0 {
+		fwd.MachineID = f.machineID
+	}
+	fwd.Timestamp, fwd.TimestampMs = common.Stamp(f.clock, fwd.Timestamp, fwd.TimestampMs)
+
+	if err := f.queue.Push(&Event{
+		Kind: EventSaveInvocation,
+		Save: &fwd,
+	}); err != nil {
+		return "", err
+	}
+
+	f.events.Publish(types.Event{
+		Kind:         types.EventInvocationStarted,
+		Timestamp:    fwd.Timestamp,
+		InvocationID: fwd.InvocationID,
+		MachineID:    fwd.MachineID,
+		ParentID:     fwd.ParentID,
+		ShellLine:    fwd.ShellLine,
+	})
+	return fwd.InvocationID, nil
+}
+
+func (f *Forwarder) Notify(ctx context.Context, req *types.NotifyRequest) error {
+	fwd := *req
+	fwd.Timestamp, fwd.TimestampMs = common.Stamp(f.clock, fwd.Timestamp, fwd.TimestampMs)
+
+	if err := f.queue.Push(&Event{
+		Kind:   EventNotify,
+		Notify: &fwd,
+	}); err != nil {
+		return err
+	}
+
+	// the start of the invocation is known to the hub only, so the execution time is not reported here
+	f.events.Publish(types.Event{
+		Kind:         types.EventInvocationFinished,
+		Timestamp:    fwd.Timestamp,
+		InvocationID: fwd.InvocationID,
+		MachineID:    f.machineID,
+	})
+	return nil
+}
+
+// ListInvocations asks the hub since invocations are tracked there, the hub lists them for the forwarding
+// daemons presenting the token
+func (f *Forwarder) ListInvocations(ctx context.Context) ([]types.RunningInvocation, error) {
+	return f.hub.ListInvocations(ctx)
+}
+
+// Run delivers queued events to the hub until the context is cancelled
+func (f *Forwarder) Run(ctx context.Context) error {
+	retryInterval := time.Duration(f.config.RetryInterval)
+	if retryInterval <= 0 {
+		retryInterval = defaultRetryInterval
+	}
+
+	unauthorized := false
+	for {
+		name, ev, ok, err := f.queue.Peek()
+		if err != nil {
+			if len(name) == 0 {
+				fmt.Printf("failed to read upstream queue: %v\n", err)
+				if err := sleep(ctx, retryInterval); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("dropping upstream event: %v\n", err)
+			if err := f.queue.Remove(name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-f.queue.wakeup:
+			}
+			continue
+		}
+
+		err = f.deliver(ctx, ev)
+		if unauthorized && !errors.Is(err, rpctypes.ErrUnauthorized) {
+			unauthorized = false
+			fmt.Printf("hub accepts the upstream token again, resuming the delivery\n")
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, rpctypes.ErrUnauthorized):
+			// the events are kept, nothing is delivered until upstream.token matches rpc_token of the hub
+			if !unauthorized {
+				unauthorized = true
+				fmt.Printf("ERROR: hub %s rejected upstream.token, the events are queued until the token is fixed\n", f.config.Address)
+			}
+			if err := sleep(ctx, retryInterval); err != nil {
+				return err
+			}
+			continue
+		case errors.Is(err, rpctypes.ErrRemote), errors.Is(err, errMalformedEvent):
+			// the hub is reachable but refused the event, retrying will not help
+			fmt.Printf("upstream rejected %s event: %v\n", ev.Kind, err)
+		default:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Printf("failed to forward %s event, will retry in %s: %v\n", ev.Kind, retryInterval, err)
+			if err := sleep(ctx, retryInterval); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := f.queue.Remove(name); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Forwarder) deliver(ctx context.Context, ev *Event) error {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	switch ev.Kind {
+	case EventSaveInvocation:
+		_, err := f.hub.SaveInvocation(ctx, ev.Save)
+		return err
+	case EventNotify:
+		return f.hub.Notify(ctx, ev.Notify)
+	default:
+		return fmt.Errorf("%w: unknown kind '%s'", errMalformedEvent, ev.Kind)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
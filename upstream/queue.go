@@ -0,0 +1,140 @@
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oclaw/shnotify/common"
+	"github.com/oclaw/shnotify/types"
+)
+
+type EventKind string
+
+const (
+	EventSaveInvocation EventKind = "save-invocation"
+	EventNotify         EventKind = "notify"
+)
+
+type Event struct {
+	Kind   EventKind                `json:"kind"`
+	Save   *types.InvocationRequest `json:"save,omitempty"`
+	Notify *types.NotifyRequest     `json:"notify,omitempty"`
+}
+
+// fsQueue is a durable FIFO of the events stored as separate files in the directory.
+// File names are zero padded sequence numbers so lexical order is the order of pushes.
+type fsQueue struct {
+	dirPath string
+
+	mu      sync.Mutex
+	lastSeq uint64
+	wakeup  chan struct{}
+}
+
+const queueFileExt = ".json"
+
+func newFsQueue(dirPath string) (*fsQueue, error) {
+	if err := os.MkdirAll(dirPath, os.ModePerm); common.IgnoreErr(err, os.ErrExist) != nil {
+		return nil, err
+	}
+
+	q := &fsQueue{
+		dirPath: dirPath,
+		wakeup:  make(chan struct{}, 1),
+	}
+
+	names, err := q.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		last := names[len(names)-1]
+		if _, err := fmt.Sscanf(last, "%020d"+queueFileExt, &q.lastSeq); err != nil {
+			return nil, fmt.Errorf("malformed queue entry '%s': %w", last, err)
+		}
+	}
+
+	return q, nil
+}
+
+func (q *fsQueue) Push(ev *Event) error {
+	marshaled, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq := q.lastSeq + 1
+	name := fmt.Sprintf("%020d%s", seq, queueFileExt)
+	tmpName := path.Join(q.dirPath, "."+name)
+
+	// write + rename to never expose partially written entries to the consumer
+	if err := os.WriteFile(tmpName, marshaled, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path.Join(q.dirPath, name)); err != nil {
+		return err
+	}
+	q.lastSeq = seq
+
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Peek returns the oldest event in the queue and its name, ok is false if the queue is empty
+func (q *fsQueue) Peek() (name string, ev *Event, ok bool, err error) {
+	names, err := q.list()
+	if err != nil || len(names) == 0 {
+		return "", nil, false, err
+	}
+
+	name = names[0]
+	raw, err := os.ReadFile(path.Join(q.dirPath, name))
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	ev = &Event{}
+	if err := json.Unmarshal(raw, ev); err != nil {
+		return name, nil, false, fmt.Errorf("malformed queue entry '%s': %w", name, err)
+	}
+	return name, ev, true, nil
+}
+
+func (q *fsQueue) Remove(name string) error {
+	err := os.Remove(path.Join(q.dirPath, name))
+	return common.IgnoreErr(err, os.ErrNotExist)
+}
+
+func (q *fsQueue) Len() (int, error) {
+	names, err := q.list()
+	return len(names), err
+}
+
+func (q *fsQueue) list() ([]string, error) {
+	entries, err := os.ReadDir(q.dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, queueFileExt) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}